@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// aimeCard is one row of aime_card. A user can have several cards linked.
+type aimeCard struct {
+	ID            int64
+	AccessCode    string
+	CreatedDate   string
+	LastLoginDate string
+	IsLocked      bool
+	IsBanned      bool
+}
+
+func fetchUserCards(db *sql.DB, userID string) ([]aimeCard, error) {
+	rows, err := db.Query("SELECT id, access_code, created_date, last_login_date, is_locked, is_banned FROM aime_card WHERE user = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch aime cards: %w", err)
+	}
+	defer rows.Close()
+
+	cards := []aimeCard{}
+	for rows.Next() {
+		var card aimeCard
+		var createdDate, lastLoginDate sql.NullString
+		var isLocked, isBanned sql.NullBool
+		if err := rows.Scan(&card.ID, &card.AccessCode, &createdDate, &lastLoginDate, &isLocked, &isBanned); err != nil {
+			return nil, fmt.Errorf("failed to scan aime card: %w", err)
+		}
+		card.CreatedDate = createdDate.String
+		card.LastLoginDate = lastLoginDate.String
+		card.IsLocked = isLocked.Bool
+		card.IsBanned = isBanned.Bool
+		cards = append(cards, card)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return cards, nil
+}
+
+// userExists checks a user ID typed in directly instead of a card
+func userExists(db *sql.DB, userID string) error {
+	var id string
+	err := db.QueryRow("SELECT id FROM aime_user WHERE id = ?", userID).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user %s not found", userID)
+		}
+		return err
+	}
+	return nil
+}
+
+type cardsMsg struct {
+	cards []aimeCard
+	err   error
+}
+
+func fetchCardsCmd(db *sql.DB, userID string) tea.Cmd {
+	return func() tea.Msg {
+		cards, err := fetchUserCards(db, userID)
+		return cardsMsg{cards: cards, err: err}
+	}
+}
+
+func (m model) cardsView() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Aime cards of user %s (%s)\n\n", m.userAimeCardInput.Value(), m.userName)
+
+	switch {
+	case m.cardsErr != "":
+		fmt.Fprintf(&b, "Error: %s\n\n", m.cardsErr)
+	case m.cards == nil:
+		b.WriteString("Loading...\n\n")
+	default:
+		fmt.Fprintf(&b, "  %-6s %-20s %-19s %-19s %s\n", "ID", "Access code", "Created", "Last login", "Status")
+		for _, card := range m.cards {
+			var status []string
+			if card.IsLocked {
+				status = append(status, "locked")
+			}
+			if card.IsBanned {
+				status = append(status, "banned")
+			}
+			if card.AccessCode == m.card {
+				status = append(status, "used for export")
+			}
+			fmt.Fprintf(&b, "  %-6d %-20s %-19s %-19s %s\n", card.ID, card.AccessCode, card.CreatedDate, card.LastLoginDate, strings.Join(status, ", "))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("Press Esc to go back.")
+	return b.String()
+}
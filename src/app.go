@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -14,20 +15,8 @@ import (
 	"github.com/joho/godotenv"
 )
 
-func userFromAimeID(db *sql.DB, aimeCardID string) (string, error) {
-	var user string
-	query := "SELECT user FROM aime_card WHERE access_code = ?"
-	err := db.QueryRow(query, aimeCardID).Scan(&user)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return "", fmt.Errorf("aime card not found")
-		}
-		return "", err
-	}
-	return user, nil
-}
-
 type userNameMsg string
+type ratingMsg string
 type totalUsersMsg map[string]int
 
 type gameItem struct {
@@ -63,32 +52,56 @@ func fetchUserCounts(db *sql.DB) tea.Cmd {
 		}
 		totalUsers["MaiMai"] = maiMaiCount
 
+		// WACCA
+		var waccaCount int
+		err = db.QueryRow("SELECT COUNT(DISTINCT user) FROM wacca_profile").Scan(&waccaCount)
+		if err != nil {
+			log.Fatal(err)
+		}
+		totalUsers["WACCA"] = waccaCount
+
 		return totalUsersMsg(totalUsers)
 	}
 }
 
+var errInvalidGame = errors.New("invalid game")
+
+// queryUserName looks up the users userName for a game
+func queryUserName(db *sql.DB, game string, userID string) (string, error) {
+	var userName string
+	var query string
+
+	switch game {
+	case "Chunithm":
+		query = "SELECT userName FROM chuni_profile_data WHERE user =  ?"
+	case "Ongeki":
+		query = "SELECT userName FROM ongeki_profile_data WHERE user = ?"
+	case "MaiMai":
+		query = "SELECT userName FROM mai2_profile_detail WHERE user = ?"
+	case "WACCA":
+		query = "SELECT username FROM wacca_profile WHERE user = ?"
+	default:
+		return "", errInvalidGame
+	}
+
+	err := db.QueryRow(query, userID).Scan(&userName)
+	if err != nil {
+		return "", err
+	}
+	return userName, nil
+}
+
 // Fetches the users userName based off the user ID
 func fetchUserName(db *sql.DB, game string, userID string) tea.Cmd {
 	return func() tea.Msg {
-		var userName string
-		var query string
-
-		switch game {
-		case "Chunithm":
-			query = "SELECT userName FROM chuni_profile_data WHERE user =  ?"
-		case "Ongeki":
-			query = "SELECT userName FROM ongeki_profile_data WHERE user = ?"
-		case "MaiMai":
-			query = "SELECT userName FROM mai2_profile_detail WHERE user = ?"
-		default:
-			return userNameMsg("Invalid game")
-		}
-
-		err := db.QueryRow(query, userID).Scan(&userName)
+		userName, err := queryUserName(db, game, userID)
 		if err != nil {
 			if err == sql.ErrNoRows {
 				return userNameMsg("User not found")
 			}
+			if err == errInvalidGame {
+				return userNameMsg("Invalid game")
+			}
 			log.Fatal(err)
 		}
 
@@ -96,6 +109,30 @@ func fetchUserName(db *sql.DB, game string, userID string) tea.Cmd {
 	}
 }
 
+// Fetches the users rating for the userDisplay view
+func fetchUserRating(db *sql.DB, game string, userID string) tea.Cmd {
+	return func() tea.Msg {
+		switch game {
+		case "Chunithm":
+			rating, err := fetchChuniPlayerRating(db, userID)
+			if err != nil {
+				return ratingMsg(fmt.Sprintf("unavailable (%v)", err))
+			}
+			return ratingMsg(fmt.Sprintf("%.2f (%s)", rating.Rating, *getChuniTachiColour(rating.Rating)))
+		case "Ongeki":
+			rating, err := fetchOngekiPlayerRating(db, userID)
+			if err != nil {
+				return ratingMsg(fmt.Sprintf("unavailable (%v)", err))
+			}
+			if rating.HasPlatinum {
+				return ratingMsg(fmt.Sprintf("%.2f (platinum %.2f)", rating.Rating, rating.PlatinumRating))
+			}
+			return ratingMsg(fmt.Sprintf("%.2f", rating.Rating))
+		}
+		return ratingMsg("")
+	}
+}
+
 func (i gameItem) FilterValue() string { return i.gameName }
 func (i gameItem) Title() string       { return fmt.Sprintf("%s (%d users)", i.gameName, i.gameCount) }
 func (i gameItem) Description() string { return "" }
@@ -105,14 +142,47 @@ type model struct {
 	selectedGame      string
 	userAimeCardInput textinput.Model
 	userName          string
+	rating            string
 	totalUsers        map[string]int
 	db                *sql.DB
 	view              string
 	games             []string
+	filter            exportFilter
+	rules             exclusionRules
+	worldsEnd         string
+	version           string
+	bestOnly          bool
+	skipSent          bool
+	anomalyPolicy     anomalyPolicy
+	chartNotes        chartNoteCounts
+	noteMismatch      noteMismatchPolicy
+	format            exportFormat
+	filterInputs      []textinput.Model
+	filterFocus       int
+	filterErr         string
+	stats             *userStats
+	statsChart        int
+	statsErr          string
+	card              string
+	cardLookup        string
+	inputErr          string
+	lookupByUser      bool
+	cards             []aimeCard
+	cardsErr          string
+	history           []historyEntry
+	historyCursor     int
+	historyStatus     string
+	historyErr        string
+	leaderboardCharts []chartKey
+	leaderboardChart  int
+	leaderboard       *leaderboard
+	improvements      []leaderboardImprovement
+	leaderboardErr    string
+	leaderboardStatus string
 }
 
-func initialModel(db *sql.DB) model {
-	games := []string{"Chunithm", "Ongeki", "MaiMai"}
+func initialModel(db *sql.DB, rules exclusionRules) model {
+	games := []string{"Chunithm", "Ongeki", "MaiMai", "WACCA"}
 
 	var items []list.Item
 	for _, game := range games {
@@ -146,7 +216,7 @@ func initialModel(db *sql.DB) model {
 	userIDInput := textinput.New()
 	userIDInput.Placeholder = "Enter Aime Card ID"
 	userIDInput.Focus()
-	userIDInput.CharLimit = 32
+	userIDInput.CharLimit = 260 // long enough for a path to aime.txt
 
 	return model{
 		list:              list,
@@ -155,6 +225,30 @@ func initialModel(db *sql.DB) model {
 		db:                db,
 		view:              "gameSelection",
 		games:             games,
+		filterInputs:      newFilterInputs(),
+		rules:             rules,
+	}
+}
+
+// exportOptions collects the export settings chosen in the TUI
+func (m model) exportOptions() exportOptions {
+	var chartNotesPath string
+	if m.chartNotes != nil {
+		chartNotesPath = chartNotesFile
+	}
+	return exportOptions{
+		Filter:         m.filter,
+		Rules:          m.rules,
+		WorldsEnd:      m.worldsEnd,
+		Version:        m.version,
+		BestOnly:       m.bestOnly,
+		SkipSent:       m.skipSent,
+		AnomalyPolicy:  m.anomalyPolicy,
+		ChartNotes:     m.chartNotes,
+		ChartNotesPath: chartNotesPath,
+		NoteMismatch:   m.noteMismatch,
+		Format:         m.format,
+		Card:           m.card,
 	}
 }
 
@@ -162,6 +256,40 @@ func (m model) Init() tea.Cmd {
 	return fetchUserCounts(m.db)
 }
 
+// submitUserInput looks up the user typed into the card input, either by one
+// of their cards or directly by user ID
+func (m model) submitUserInput() (tea.Model, tea.Cmd) {
+	aimeCardID := m.userAimeCardInput.Value()
+	if aimeCardID == "" {
+		return m, nil
+	}
+
+	user := aimeCardID
+	m.card = ""
+	m.cardLookup = ""
+	if m.lookupByUser {
+		if err := userExists(m.db, user); err != nil {
+			log.Printf("Error fetching user: %v", err)
+			m.inputErr = err.Error()
+			return m, nil
+		}
+	} else {
+		lookup, err := lookupAimeCard(m.db, aimeCardID)
+		if err != nil {
+			log.Printf("Error fetching user ID from Aime card: %v", err)
+			m.inputErr = err.Error()
+			return m, nil
+		}
+		user = lookup.UserID
+		m.card = lookup.AccessCode
+		m.cardLookup = lookup.String()
+	}
+	m.inputErr = ""
+	m.userAimeCardInput.SetValue(user) // Store the user ID for later use
+	m.rating = ""
+	return m, tea.Batch(fetchUserName(m.db, m.selectedGame, user), fetchUserRating(m.db, m.selectedGame, user))
+}
+
 // Update is called when messages are received. The idea is that you inspect the
 // message and send back an updated model accordingly. You can also return
 // a command, which is a function that performs I/O and returns a message.
@@ -169,8 +297,24 @@ func (m model) Init() tea.Cmd {
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.view == "filterForm" {
+			return m.updateFilterForm(msg)
+		}
+		if m.view == "stats" {
+			return m.updateStats(msg)
+		}
+		if m.view == "history" {
+			return m.updateHistory(msg)
+		}
+		if m.view == "leaderboard" {
+			return m.updateLeaderboard(msg)
+		}
 		switch msg.String() {
 		case "ctrl+c", "q":
+			// q can be part of a typed aime.txt path
+			if msg.String() == "q" && m.view == "aimeCardInput" {
+				break
+			}
 			return m, tea.Quit
 		case "enter":
 			if m.view == "gameSelection" {
@@ -182,60 +326,151 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				return m, nil
 			} else if m.view == "aimeCardInput" {
-				aimeCardID := m.userAimeCardInput.Value()
-				if aimeCardID != "" {
-					user, err := userFromAimeID(m.db, aimeCardID)
-					if err != nil {
-						log.Printf("Error fetching user ID from Aime card: %v", err)
-						return m, nil
-					}
-					m.userAimeCardInput.SetValue(user) // Store the user ID for later use
-					return m, fetchUserName(m.db, m.selectedGame, user)
+				return m.submitUserInput()
+			}
+		case "ctrl+l":
+			// Use the typed path as an aime.txt or segatools.ini to read the card from
+			if m.view == "aimeCardInput" {
+				code, err := readSegatoolsAccessCode(m.userAimeCardInput.Value())
+				if err != nil {
+					log.Printf("Error reading access code: %v", err)
+					m.inputErr = err.Error()
+					return m, nil
 				}
+				m.lookupByUser = false
+				m.userAimeCardInput.Placeholder = "Enter Aime Card ID"
+				m.userAimeCardInput.SetValue(code)
+				return m.submitUserInput()
+			}
+		case "tab":
+			// Switch between looking users up by Aime card and by user ID
+			if m.view == "aimeCardInput" {
+				m.lookupByUser = !m.lookupByUser
+				if m.lookupByUser {
+					m.userAimeCardInput.Placeholder = "Enter User ID"
+				} else {
+					m.userAimeCardInput.Placeholder = "Enter Aime Card ID"
+				}
+				return m, nil
 			}
 		case "esc": // clear everything if esc is  pressed and go to home view
+			if m.view == "cards" {
+				m.view = "userDisplay"
+				return m, nil
+			}
 			if m.view == "aimeCardInput" || m.view == "userDisplay" {
 				m.view = "gameSelection"
 				m.userName = ""
 				m.userAimeCardInput.Reset()
+				m.version = ""
+				m.card = ""
+				m.cardLookup = ""
+				m.inputErr = ""
 				return m, nil
 			}
 		case "e":
 			if m.view == "userDisplay" {
 				userID := m.userAimeCardInput.Value() // get user value from fetchUserIDFromAimeCard() (aime card input) and use it
-				switch m.selectedGame {
-				case "Chunithm":
-					ChunitachiExport, err := fetchChuniTachiExport(m.db, userID) // use retrevied user id from aime card
-					if err != nil {
-						log.Printf("Error fetching ChuniTachi export: %v", err)
-						return m, nil
-					}
-					if err := exportChuniToTachi(ChunitachiExport); err != nil {
-						log.Printf("Error exporting Chuni to Tachi: %v", err)
-						return m, nil
-					}
-					fmt.Println("Exported to Tachi and saved to chuni_tachi_export.json")
-
-				case "Ongeki":
-					GekiTachiExport, err := fetchOngekiExport(m.db, userID)
+				result, err := exportForGame(m.db, m.selectedGame, userID, m.exportOptions())
+				if err != nil {
+					log.Printf("%v", err)
+					return m, nil
+				}
+				fmt.Println(result)
+				return m, nil
+			}
+		case "f":
+			if m.view == "userDisplay" {
+				return m.openFilterForm()
+			}
+		case "w":
+			// Cycle the WORLD'S END archive format: off -> json -> csv
+			if m.view == "userDisplay" && m.selectedGame == "Chunithm" {
+				switch m.worldsEnd {
+				case "":
+					m.worldsEnd = "json"
+				case "json":
+					m.worldsEnd = "csv"
+				default:
+					m.worldsEnd = ""
+				}
+				return m, nil
+			}
+		case "r":
+			if m.view == "userDisplay" {
+				path, err := writeRatingSummary(m.db, m.selectedGame, m.userAimeCardInput.Value())
+				if err != nil {
+					log.Printf("Error writing rating summary: %v", err)
+					return m, nil
+				}
+				fmt.Printf("Rating summary saved to %s\n", path)
+				return m, nil
+			}
+		case "c":
+			if m.view == "userDisplay" {
+				m.view = "cards"
+				m.cards = nil
+				m.cardsErr = ""
+				return m, fetchCardsCmd(m.db, m.userAimeCardInput.Value())
+			}
+		case "b":
+			if m.view == "userDisplay" {
+				m.bestOnly = !m.bestOnly
+				return m, nil
+			}
+		case "h":
+			if m.view == "userDisplay" {
+				return m.openHistoryView()
+			}
+		case "l":
+			if m.view == "userDisplay" && (m.selectedGame == "Chunithm" || m.selectedGame == "Ongeki") {
+				return m.openLeaderboardView()
+			}
+		case "a":
+			if m.view == "userDisplay" && (m.selectedGame == "Chunithm" || m.selectedGame == "Ongeki") {
+				m.anomalyPolicy = nextAnomalyPolicy(m.anomalyPolicy)
+				return m, nil
+			}
+		case "n":
+			if m.view == "userDisplay" && (m.selectedGame == "Chunithm" || m.selectedGame == "Ongeki") {
+				// Cycles off -> annotate -> drop, the chart data is loaded once
+				switch {
+				case m.chartNotes == nil:
+					counts, err := loadChartNoteCounts(chartNotesFile)
 					if err != nil {
-						log.Printf("Error fetching ChuniTachi export: %v", err)
-						return m, nil
-					}
-					if err := exportOngekiToTachi(GekiTachiExport); err != nil {
-						log.Printf("Error exporting Ongeki to Tachi: %v", err)
+						log.Printf("Note count check unavailable: %v", err)
 						return m, nil
 					}
-					fmt.Println("Exported to Tachi and saved to ongeki_tachi_export.json")
-
-				case "MaiMai":
-					fmt.Println("MaiMai export not implemented yet")
-
+					m.chartNotes, m.noteMismatch = counts, noteMismatchAnnotate
+				case m.noteMismatch == noteMismatchAnnotate:
+					m.noteMismatch = noteMismatchDrop
 				default:
-					fmt.Printf("Unsupported game: %s\n", m.selectedGame)
-					return m, nil
+					m.chartNotes, m.noteMismatch = nil, ""
 				}
-
+				return m, nil
+			}
+		case "o":
+			if m.view == "userDisplay" {
+				m.format = nextExportFormat(m.format)
+				return m, nil
+			}
+		case "d":
+			if m.view == "userDisplay" {
+				m.skipSent = !m.skipSent
+				return m, nil
+			}
+		case "s":
+			if m.view == "userDisplay" {
+				m.view = "stats"
+				m.stats = nil
+				m.statsChart = 0
+				m.statsErr = ""
+				return m, fetchUserStats(m.db, m.selectedGame, m.userAimeCardInput.Value())
+			}
+		case "v":
+			// Cycle through the Chunithm versions Tachi knows about
+			if m.view == "userDisplay" && m.selectedGame == "Chunithm" {
+				m.version = nextChuniTachiVersion(m.version)
 				return m, nil
 			}
 		}
@@ -252,6 +487,23 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.userName = string(msg)
 		m.view = "userDisplay"
 		return m, nil
+	case ratingMsg:
+		m.rating = string(msg)
+		return m, nil
+	case cardsMsg:
+		if msg.err != nil {
+			m.cardsErr = msg.err.Error()
+		}
+		m.cards = msg.cards
+		return m, nil
+	case statsMsg:
+		if msg.err != nil {
+			m.statsErr = msg.err.Error()
+		}
+		m.stats = msg.stats
+		return m, nil
+	case leaderboardMsg:
+		return m.updateLeaderboardMsg(msg)
 	}
 
 	var cmd tea.Cmd
@@ -270,33 +522,111 @@ func (m model) View() string {
 	case "gameSelection":
 		return m.list.View() + "\n\nPress Enter to select a game, q to quit."
 	case "aimeCardInput":
-		return fmt.Sprintf("Selected Game: %s\nEnter Aime Card ID: %s\nPress Enter to continue, Esc to go back.", m.selectedGame, m.userAimeCardInput.View())
+		errLine := ""
+		if m.inputErr != "" {
+			errLine = fmt.Sprintf("Error: %s\n", m.inputErr)
+		}
+		if m.lookupByUser {
+			return fmt.Sprintf("Selected Game: %s\nEnter User ID: %s\n%sPress Enter to continue, Tab to use an Aime card instead, Esc to go back.", m.selectedGame, m.userAimeCardInput.View(), errLine)
+		}
+		return fmt.Sprintf("Selected Game: %s\nEnter Aime Card ID, FeliCa IDm or chip ID: %s\n%sPress Enter to continue, Ctrl+L to read the typed aime.txt or segatools.ini path, Tab to use a user ID instead, Esc to go back.", m.selectedGame, m.userAimeCardInput.View(), errLine)
 	case "userDisplay":
-		return fmt.Sprintf("Selected Game: %s\nUser ID: %s\nUserName: %s\nPress 'e' to export to Tachi, Esc to go back.", m.selectedGame, m.userAimeCardInput.Value(), m.userName)
+		view := fmt.Sprintf("Selected Game: %s\nUser ID: %s\nUserName: %s\nFilters: %s\n", m.selectedGame, m.userAimeCardInput.Value(), m.userName, m.filter)
+		if m.card != "" {
+			view += fmt.Sprintf("Card: %s ('c' to list all cards)\n", m.cardLookup)
+		} else {
+			view += "Card: none, looked up by user ID ('c' to list all cards)\n"
+		}
+		format, _ := parseExportFormat(string(m.format))
+		view += fmt.Sprintf("Format: %s ('o' to change)\n", format)
+		if m.bestOnly {
+			view += "Mode: best score per chart only ('b' to export every play)\n"
+		} else {
+			view += "Mode: every play ('b' to export best scores only)\n"
+		}
+		if m.selectedGame == "Chunithm" || m.selectedGame == "Ongeki" {
+			policy, _ := parseAnomalyPolicy(string(m.anomalyPolicy))
+			view += fmt.Sprintf("Corrupt plays: %s ('a' to change)\n", policy)
+			if m.chartNotes != nil {
+				view += fmt.Sprintf("Note counts: checked against %s, mismatches %s ('n' to change)\n", chartNotesFile, map[noteMismatchPolicy]string{noteMismatchAnnotate: "annotated", noteMismatchDrop: "dropped"}[m.noteMismatch])
+			} else {
+				view += fmt.Sprintf("Note counts: not checked ('n' to check against %s)\n", chartNotesFile)
+			}
+		}
+		if m.skipSent {
+			view += fmt.Sprintf("Dedupe: skipping scores already sent, tracked in %s ('d' to export everything)\n", defaultSentStatePath)
+		} else {
+			view += "Dedupe: off, every score is exported ('d' to skip scores already sent)\n"
+		}
+		if m.rating != "" {
+			view += fmt.Sprintf("Rating: %s ('r' to save a summary)\n", m.rating)
+		}
+		if m.selectedGame == "Chunithm" {
+			worldsEnd := m.worldsEnd
+			if worldsEnd == "" {
+				worldsEnd = "off"
+			}
+			view += fmt.Sprintf("WORLD'S END archive: %s ('w' to change)\n", worldsEnd)
+			version := m.version
+			if version == "" {
+				version = "all"
+			}
+			view += fmt.Sprintf("Version: %s ('v' to change)\n", version)
+		}
+		if m.selectedGame == "Chunithm" || m.selectedGame == "Ongeki" {
+			view += "Press 'l' for server leaderboards.\n"
+		}
+		return view + "Press 'e' to export to Tachi, 'f' to edit filters, 's' for stats, 'h' for export history, Esc to go back."
+	case "filterForm":
+		return m.filterFormView()
+	case "stats":
+		return m.statsView()
+	case "cards":
+		return m.cardsView()
+	case "history":
+		return m.historyView()
+	case "leaderboard":
+		return m.leaderboardView()
 	}
 	return ""
 }
 
-func main() {
-
+// openDB connects to the Artemis database configured in the .env file
+func openDB() (*sql.DB, error) {
 	err := godotenv.Load()
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		return nil, fmt.Errorf("error loading .env file")
 	}
 
 	dbURL := os.Getenv("DB_URL")
 	if dbURL == "" {
-		log.Fatal("DB_URL is not set in the .env file")
+		return nil, fmt.Errorf("DB_URL is not set in the .env file")
 	}
 
 	// Connect to MySQL
-	db, err := sql.Open("mysql", dbURL)
+	return sql.Open("mysql", dbURL)
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if err := runCLI(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	rules, err := loadExclusionRules("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := openDB()
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer db.Close()
 
-	p := tea.NewProgram(initialModel(db))
+	p := tea.NewProgram(initialModel(db, rules))
 	if _, err := p.Run(); err != nil {
 		fmt.Println("Error running program:", err)
 		os.Exit(1)
@@ -0,0 +1,101 @@
+package main
+
+// collapsibleScore is implemented by the batch-manual score types so exports
+// can be collapsed to one score per chart
+type collapsibleScore[T any] interface {
+	bestKey() chartKey
+	scoreValue() int
+	lampRank() int
+	// withLamp returns the score with the lamp of other. When the lamp came
+	// from a different play the judgements and optional fields are dropped,
+	// since they would not match it.
+	withLamp(other T) T
+}
+
+// bestCollector keeps one score per (identifier, difficulty): the play with
+// the best score, including its timestamp, carrying the best lamp seen on
+// that chart. Its judgements are kept unless the lamp came from a different
+// play. Charts keep the order of their first play. Its size only grows with
+// the number of charts played, so streamed exports can collapse without
+// holding every play.
+type bestCollector[T collapsibleScore[T]] struct {
+	index     map[chartKey]int
+	bests     []T
+	bestLamps []T
+}
+
+func newBestCollector[T collapsibleScore[T]]() *bestCollector[T] {
+	return &bestCollector[T]{index: make(map[chartKey]int)}
+}
+
+func (c *bestCollector[T]) add(score T) {
+	key := score.bestKey()
+	i, ok := c.index[key]
+	if !ok {
+		c.index[key] = len(c.bests)
+		c.bests = append(c.bests, score)
+		c.bestLamps = append(c.bestLamps, score)
+		return
+	}
+	if score.scoreValue() > c.bests[i].scoreValue() {
+		c.bests[i] = score
+	}
+	if score.lampRank() > c.bestLamps[i].lampRank() {
+		c.bestLamps[i] = score
+	}
+}
+
+func (c *bestCollector[T]) result() []T {
+	bests := make([]T, 0, len(c.bests))
+	for i := range c.bests {
+		bests = append(bests, c.bests[i].withLamp(c.bestLamps[i]))
+	}
+	return bests
+}
+
+// collapseToBests collapses a whole export with a bestCollector
+func collapseToBests[T collapsibleScore[T]](scores []T) []T {
+	if scores == nil {
+		return nil
+	}
+
+	collector := newBestCollector[T]()
+	for _, score := range scores {
+		collector.add(score)
+	}
+	return collector.result()
+}
+
+func (s BatchManualScoreChuni) bestKey() chartKey { return chartKey{s.Identifier, s.Difficulty} }
+func (s BatchManualScoreChuni) scoreValue() int   { return s.Score }
+func (s BatchManualScoreChuni) lampRank() int     { return chuniLampRanks[s.Lamp] }
+func (s BatchManualScoreChuni) withLamp(other BatchManualScoreChuni) BatchManualScoreChuni {
+	if other.Lamp != s.Lamp {
+		s.Lamp = other.Lamp
+		s.Judgements, s.Optional = nil, nil
+		s.RawJudgements = nil
+	}
+	return s
+}
+
+func (s BatchManualScoreGeki) bestKey() chartKey { return chartKey{s.Identifier, s.Difficulty} }
+func (s BatchManualScoreGeki) scoreValue() int   { return s.Score }
+func (s BatchManualScoreGeki) lampRank() int     { return gekiLampRanks[s.Lamp] }
+func (s BatchManualScoreGeki) withLamp(other BatchManualScoreGeki) BatchManualScoreGeki {
+	if other.Lamp != s.Lamp {
+		s.Lamp = other.Lamp
+		s.Judgements, s.Optional = nil, nil
+	}
+	return s
+}
+
+func (s BatchManualScoreWacca) bestKey() chartKey { return chartKey{s.Identifier, s.Difficulty} }
+func (s BatchManualScoreWacca) scoreValue() int   { return s.Score }
+func (s BatchManualScoreWacca) lampRank() int     { return waccaLampRanks[s.Lamp] }
+func (s BatchManualScoreWacca) withLamp(other BatchManualScoreWacca) BatchManualScoreWacca {
+	if other.Lamp != s.Lamp {
+		s.Lamp = other.Lamp
+		s.Judgements, s.Optional = nil, nil
+	}
+	return s
+}
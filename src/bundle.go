@@ -0,0 +1,466 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A bundle is a zip archive holding manifest.json and one JSON array of rows
+// per table under tables/. bundleVersion is raised whenever that layout
+// changes, restore refuses bundles newer than it understands.
+const (
+	bundleFormat  = "artemis2tachi-bundle"
+	bundleVersion = 1
+)
+
+type bundleTable struct {
+	Name string `json:"name"`
+	File string `json:"file"`
+	Rows int    `json:"rows"`
+	// BinaryColumns hold base64 encoded values
+	BinaryColumns []string `json:"binaryColumns,omitempty"`
+}
+
+type bundleManifest struct {
+	Format    string        `json:"format"`
+	Version   int           `json:"version"`
+	CreatedAt time.Time     `json:"createdAt"`
+	UserID    string        `json:"userId"`
+	Tables    []bundleTable `json:"tables"`
+}
+
+func (m bundleManifest) rows() int {
+	total := 0
+	for _, table := range m.Tables {
+		total += table.Rows
+	}
+	return total
+}
+
+// bundleGameTables finds every Chunithm, Ongeki and maimai table keyed by
+// user, so profiles, items, characters, bests and playlogs of any Artemis
+// version are picked up without listing them here
+func bundleGameTables(db *sql.DB) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT TABLE_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND COLUMN_NAME = 'user'
+		AND (TABLE_NAME LIKE 'chuni\_%' OR TABLE_NAME LIKE 'ongeki\_%' OR TABLE_NAME LIKE 'mai2\_%')
+		ORDER BY TABLE_NAME
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list game tables: %w", err)
+	}
+	defer rows.Close()
+
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			return nil, err
+		}
+		tables = append(tables, table)
+	}
+	return tables, rows.Err()
+}
+
+// bundleUserColumn is the column holding the user ID in a bundled table
+func bundleUserColumn(table string) string {
+	if table == "aime_user" {
+		return "id"
+	}
+	return "user"
+}
+
+func isBinaryColumn(column *sql.ColumnType) bool {
+	switch column.DatabaseTypeName() {
+	case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BIT":
+		return true
+	}
+	return false
+}
+
+// writeBundle exports everything Artemis stores for a user to a bundle at path
+func writeBundle(db *sql.DB, userID string, path string) (bundleManifest, error) {
+	manifest := bundleManifest{Format: bundleFormat, Version: bundleVersion, CreatedAt: time.Now().UTC(), UserID: userID}
+
+	gameTables, err := bundleGameTables(db)
+	if err != nil {
+		return manifest, err
+	}
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return manifest, fmt.Errorf("failed to create bundle directory: %w", err)
+		}
+	}
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return manifest, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer os.Remove(path + ".tmp")
+	defer file.Close()
+
+	archive := zip.NewWriter(file)
+	for _, table := range append([]string{"aime_user", "aime_card"}, gameTables...) {
+		entry, err := writeBundleTable(db, archive, table, userID)
+		if err != nil {
+			return manifest, err
+		}
+		manifest.Tables = append(manifest.Tables, entry)
+	}
+
+	w, err := archive.Create("manifest.json")
+	if err != nil {
+		return manifest, err
+	}
+	data, err := json.MarshalIndent(manifest, "", " ")
+	if err != nil {
+		return manifest, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return manifest, err
+	}
+	if err := archive.Close(); err != nil {
+		return manifest, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return manifest, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return manifest, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return manifest, nil
+}
+
+// writeBundleTable streams the user's rows of one table into the archive
+func writeBundleTable(db *sql.DB, archive *zip.Writer, table string, userID string) (bundleTable, error) {
+	entry := bundleTable{Name: table, File: "tables/" + table + ".json"}
+
+	rows, err := db.Query(fmt.Sprintf("SELECT * FROM `%s` WHERE `%s` = ?", table, bundleUserColumn(table)), userID)
+	if err != nil {
+		return entry, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	columns, err := rows.ColumnTypes()
+	if err != nil {
+		return entry, err
+	}
+	for _, column := range columns {
+		if isBinaryColumn(column) {
+			entry.BinaryColumns = append(entry.BinaryColumns, column.Name())
+		}
+	}
+
+	w, err := archive.Create(entry.File)
+	if err != nil {
+		return entry, err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return entry, err
+	}
+
+	values := make([]any, len(columns))
+	pointers := make([]any, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return entry, fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			row[column.Name()] = bundleValue(values[i], isBinaryColumn(column))
+		}
+		data, err := json.Marshal(row)
+		if err != nil {
+			return entry, fmt.Errorf("failed to encode %s row: %w", table, err)
+		}
+		separator := ",\n"
+		if entry.Rows == 0 {
+			separator = "\n"
+		}
+		if _, err := io.WriteString(w, separator+string(data)); err != nil {
+			return entry, err
+		}
+		entry.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return entry, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	_, err = io.WriteString(w, "\n]\n")
+	return entry, err
+}
+
+// bundleValue turns a scanned column into something JSON keeps intact
+func bundleValue(value any, binary bool) any {
+	switch v := value.(type) {
+	case []byte:
+		if binary {
+			return base64.StdEncoding.EncodeToString(v)
+		}
+		return string(v)
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999")
+	default:
+		return v
+	}
+}
+
+// readBundle opens a bundle and checks it is one this version can restore
+func readBundle(path string) (*zip.ReadCloser, bundleManifest, error) {
+	var manifest bundleManifest
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, manifest, fmt.Errorf("failed to open bundle: %w", err)
+	}
+
+	data, err := readBundleFile(archive, "manifest.json")
+	if err == nil {
+		err = json.Unmarshal(data, &manifest)
+	}
+	if err != nil {
+		archive.Close()
+		return nil, manifest, fmt.Errorf("invalid bundle manifest: %w", err)
+	}
+	if manifest.Format != bundleFormat {
+		archive.Close()
+		return nil, manifest, fmt.Errorf("%s is not an artemis2tachi bundle", path)
+	}
+	if manifest.Version > bundleVersion {
+		archive.Close()
+		return nil, manifest, fmt.Errorf("bundle version %d is newer than the supported version %d", manifest.Version, bundleVersion)
+	}
+	return archive, manifest, nil
+}
+
+func readBundleFile(archive *zip.ReadCloser, name string) ([]byte, error) {
+	file, err := archive.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+// restoreResult counts what a restore wrote (or would write on a dry run)
+type restoreResult struct {
+	UserID        int64
+	Tables        int
+	Rows          int
+	SkippedCards  int
+	SkippedTables []string
+	// SkippedColumns exist in the bundle but not on the target server
+	SkippedColumns []string
+}
+
+func (r restoreResult) String() string {
+	summary := fmt.Sprintf("user %d: %d rows in %d tables", r.UserID, r.Rows, r.Tables)
+	if r.SkippedCards > 0 {
+		summary += fmt.Sprintf(", %d cards already registered on the target left out", r.SkippedCards)
+	}
+	if len(r.SkippedTables) > 0 {
+		summary += fmt.Sprintf(", tables missing on the target: %s", strings.Join(r.SkippedTables, ", "))
+	}
+	if len(r.SkippedColumns) > 0 {
+		summary += fmt.Sprintf(", columns missing on the target: %s", strings.Join(r.SkippedColumns, ", "))
+	}
+	return summary
+}
+
+// targetColumns lists the columns of a table on the target server, nil when
+// the table does not exist there
+func targetColumns(tx *sql.Tx, table string) (map[string]bool, error) {
+	rows, err := tx.Query(`
+		SELECT COLUMN_NAME FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?
+	`, table)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s columns: %w", table, err)
+	}
+	defer rows.Close()
+
+	var columns map[string]bool
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		if columns == nil {
+			columns = make(map[string]bool)
+		}
+		columns[column] = true
+	}
+	return columns, rows.Err()
+}
+
+// restoreBundle imports a bundle into db. The rows get new IDs and belong to
+// a new user, or to targetUser when given. Everything runs in one transaction
+// and a dry run rolls it back after counting the rows.
+func restoreBundle(db *sql.DB, path string, targetUser string, dryRun bool) (restoreResult, error) {
+	var result restoreResult
+
+	archive, manifest, err := readBundle(path)
+	if err != nil {
+		return result, err
+	}
+	defer archive.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if targetUser != "" {
+		var exists int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM aime_user WHERE id = ?", targetUser).Scan(&exists); err != nil {
+			return result, fmt.Errorf("failed to look up user %s: %w", targetUser, err)
+		}
+		if exists == 0 {
+			return result, fmt.Errorf("no user with ID %s on the target server", targetUser)
+		}
+		if result.UserID, err = strconv.ParseInt(targetUser, 10, 64); err != nil {
+			return result, fmt.Errorf("invalid user ID %q", targetUser)
+		}
+	}
+
+	for _, table := range manifest.Tables {
+		// An existing user keeps their account row
+		if table.Name == "aime_user" && targetUser != "" {
+			continue
+		}
+
+		columns, err := targetColumns(tx, table.Name)
+		if err != nil {
+			return result, err
+		}
+		if columns == nil {
+			if table.Rows > 0 {
+				result.SkippedTables = append(result.SkippedTables, table.Name)
+			}
+			continue
+		}
+
+		rows, err := readBundleRows(archive, table)
+		if err != nil {
+			return result, err
+		}
+		if table.Name == "aime_user" && len(rows) != 1 {
+			return result, fmt.Errorf("bundle holds %d aime_user rows, expected 1", len(rows))
+		}
+
+		skipped := make(map[string]bool)
+		for _, row := range rows {
+			if table.Name == "aime_card" {
+				var owner int64
+				err := tx.QueryRow("SELECT user FROM aime_card WHERE access_code = ?", row["access_code"]).Scan(&owner)
+				if err == nil {
+					result.SkippedCards++
+					continue
+				}
+				if err != sql.ErrNoRows {
+					return result, fmt.Errorf("failed to check card %v: %w", row["access_code"], err)
+				}
+			}
+
+			// IDs are given out again by the target, the owner is remapped
+			delete(row, "id")
+			if table.Name != "aime_user" {
+				row["user"] = result.UserID
+			}
+
+			var names, placeholders []string
+			var args []any
+			for _, name := range sortedKeys(row) {
+				if !columns[name] {
+					skipped[table.Name+"."+name] = true
+					continue
+				}
+				names = append(names, "`"+name+"`")
+				placeholders = append(placeholders, "?")
+				args = append(args, row[name])
+			}
+			res, err := tx.Exec(fmt.Sprintf("INSERT INTO `%s` (%s) VALUES (%s)", table.Name, strings.Join(names, ", "), strings.Join(placeholders, ", ")), args...)
+			if err != nil {
+				return result, fmt.Errorf("failed to restore %s: %w", table.Name, err)
+			}
+			if table.Name == "aime_user" {
+				if result.UserID, err = res.LastInsertId(); err != nil {
+					return result, err
+				}
+			}
+			result.Rows++
+		}
+		if len(rows) > 0 {
+			result.Tables++
+		}
+		result.SkippedColumns = append(result.SkippedColumns, sortedKeys(skipped)...)
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit restore: %w", err)
+	}
+	return result, nil
+}
+
+// readBundleRows loads one table of a bundle, decoding its binary columns
+func readBundleRows(archive *zip.ReadCloser, table bundleTable) ([]map[string]any, error) {
+	data, err := readBundleFile(archive, table.File)
+	if err != nil {
+		return nil, fmt.Errorf("bundle is missing %s: %w", table.File, err)
+	}
+
+	// Numbers stay exact instead of going through float64
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var rows []map[string]any
+	if err := decoder.Decode(&rows); err != nil {
+		return nil, fmt.Errorf("invalid %s in bundle: %w", table.File, err)
+	}
+
+	for _, row := range rows {
+		for name, value := range row {
+			switch v := value.(type) {
+			case json.Number:
+				row[name] = v.String()
+			case string:
+				if slices.Contains(table.BinaryColumns, name) {
+					decoded, err := base64.StdEncoding.DecodeString(v)
+					if err != nil {
+						return nil, fmt.Errorf("invalid %s.%s in bundle: %w", table.Name, name, err)
+					}
+					row[name] = decoded
+				}
+			case map[string]any, []any:
+				return nil, fmt.Errorf("invalid %s.%s in bundle: nested value", table.Name, name)
+			}
+		}
+	}
+	return rows, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type cardFormat string
+
+const (
+	cardFormatAccessCode cardFormat = "access code"
+	cardFormatFeliCaIDm  cardFormat = "FeliCa IDm"
+	cardFormatChipID     cardFormat = "chip ID"
+)
+
+// cardLookup describes how a typed card number was matched to a user
+type cardLookup struct {
+	Input      string
+	Format     cardFormat
+	AccessCode string
+	UserID     string
+	// Column is the aime_card column the user was found through
+	Column string
+}
+
+func (c cardLookup) String() string {
+	switch {
+	case c.Format == cardFormatAccessCode:
+		return fmt.Sprintf("%s %s", c.Format, c.AccessCode)
+	case c.Column == "access_code":
+		return fmt.Sprintf("%s %s, converted to access code %s", c.Format, c.Input, c.AccessCode)
+	default:
+		return fmt.Sprintf("%s %s, matched on %s (access code %s)", c.Format, c.Input, c.Column, c.AccessCode)
+	}
+}
+
+// normalizeCardInput removes the separators printed on cards and the 0x
+// prefix readers show in front of an IDm
+func normalizeCardInput(input string) string {
+	input = strings.NewReplacer(" ", "", "-", "", "\t", "").Replace(strings.TrimSpace(input))
+	input = strings.TrimPrefix(strings.TrimPrefix(input, "0x"), "0X")
+	return strings.ToUpper(input)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isHex(s string) bool {
+	_, err := strconv.ParseUint(s, 16, 64)
+	return err == nil
+}
+
+// detectCardFormat works out what kind of number was typed in. Aime and
+// Amusement IC cards print their 20 digit access code, FeliCa cards (e-amusement
+// pass, phones, transit cards) are identified by their 16 hex digit IDm.
+func detectCardFormat(input string) (cardFormat, error) {
+	switch {
+	case input == "":
+		return "", fmt.Errorf("no card number given")
+	case len(input) == 20 && isDigits(input):
+		return cardFormatAccessCode, nil
+	case len(input) == 16 && isHex(input):
+		return cardFormatFeliCaIDm, nil
+	case len(input) < 20 && isDigits(input):
+		return cardFormatChipID, nil
+	case isDigits(input):
+		return "", fmt.Errorf("%q has %d digits, an access code has 20", input, len(input))
+	default:
+		return "", fmt.Errorf("%q is neither a 20 digit access code nor a 16 hex digit FeliCa IDm", input)
+	}
+}
+
+// felicaAccessCode converts an IDm to an access code the way Artemis' aimedb
+// does for FeliCa lookups: the IDm read as a number, zero padded to 20 digits
+func felicaAccessCode(idm string) (string, error) {
+	value, err := strconv.ParseUint(idm, 16, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid FeliCa IDm %q: %w", idm, err)
+	}
+	return fmt.Sprintf("%020d", value), nil
+}
+
+// aimeCardHasColumn reports whether this Artemis schema has an optional
+// aime_card column, older databases have neither idm nor chip_id
+func aimeCardHasColumn(db *sql.DB, column string) (bool, error) {
+	var count int
+	err := db.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'aime_card' AND COLUMN_NAME = ?
+	`, column).Scan(&count)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect aime_card columns: %w", err)
+	}
+	return count > 0, nil
+}
+
+// lookupAimeCardBy finds a card by one column, ok is false when no card matches
+func lookupAimeCardBy(db *sql.DB, column string, value string) (string, string, bool, error) {
+	var user, accessCode string
+	query := fmt.Sprintf("SELECT user, access_code FROM aime_card WHERE %s = ?", column)
+	if column == "idm" {
+		query = "SELECT user, access_code FROM aime_card WHERE UPPER(idm) = ?"
+	}
+	err := db.QueryRow(query, value).Scan(&user, &accessCode)
+	if err == sql.ErrNoRows {
+		return "", "", false, nil
+	}
+	if err != nil {
+		return "", "", false, err
+	}
+	return user, accessCode, true, nil
+}
+
+// lookupAimeCard normalizes a typed card number, detects its format and finds
+// the user it belongs to
+func lookupAimeCard(db *sql.DB, input string) (cardLookup, error) {
+	normalized := normalizeCardInput(input)
+	format, err := detectCardFormat(normalized)
+	if err != nil {
+		return cardLookup{}, err
+	}
+	lookup := cardLookup{Input: normalized, Format: format}
+
+	// Candidate (column, value) pairs, tried in order
+	var candidates [][2]string
+	switch format {
+	case cardFormatAccessCode:
+		candidates = append(candidates, [2]string{"access_code", normalized})
+	case cardFormatFeliCaIDm:
+		accessCode, err := felicaAccessCode(normalized)
+		if err != nil {
+			return cardLookup{}, err
+		}
+		candidates = append(candidates, [2]string{"access_code", accessCode}, [2]string{"idm", normalized})
+	case cardFormatChipID:
+		candidates = append(candidates, [2]string{"chip_id", normalized})
+	}
+
+	for _, candidate := range candidates {
+		column, value := candidate[0], candidate[1]
+		if column != "access_code" {
+			ok, err := aimeCardHasColumn(db, column)
+			if err != nil {
+				return cardLookup{}, err
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		user, accessCode, ok, err := lookupAimeCardBy(db, column, value)
+		if err != nil {
+			return cardLookup{}, err
+		}
+		if ok {
+			lookup.UserID = user
+			lookup.AccessCode = accessCode
+			lookup.Column = column
+			return lookup, nil
+		}
+	}
+
+	if format == cardFormatChipID {
+		if ok, err := aimeCardHasColumn(db, "chip_id"); err == nil && !ok {
+			return cardLookup{}, fmt.Errorf("%q looks like a chip ID but this database has no aime_card.chip_id column, enter the 20 digit access code", normalized)
+		}
+	}
+	return cardLookup{}, fmt.Errorf("aime card not found (detected %s %s)", format, normalized)
+}
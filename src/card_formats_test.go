@@ -0,0 +1,75 @@
+package main
+
+import "testing"
+
+func TestNormalizeCardInput(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"01234567890123456789", "01234567890123456789"},
+		{" 0123 4567 8901 2345 6789 ", "01234567890123456789"},
+		{"0123-4567-8901-2345-6789", "01234567890123456789"},
+		{"0x012e4cd8b1a2c3d4", "012E4CD8B1A2C3D4"},
+		{"0X012E4CD8B1A2C3D4", "012E4CD8B1A2C3D4"},
+		{"01\t2e 4c-d8", "012E4CD8"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := normalizeCardInput(tt.input); got != tt.want {
+			t.Errorf("normalizeCardInput(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestDetectCardFormat(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    cardFormat
+		wantErr bool
+	}{
+		{"01234567890123456789", cardFormatAccessCode, false},
+		{"012E4CD8B1A2C3D4", cardFormatFeliCaIDm, false},
+		// 16 digits are read as an IDm, digits are valid hex
+		{"0123456789012345", cardFormatFeliCaIDm, false},
+		{"12345678", cardFormatChipID, false},
+		{"0123456789012345678", cardFormatChipID, false},
+		{"012345678901234567890", "", true},
+		{"012E4CD8B1A2C3", "", true},
+		{"ZZZZZZZZZZZZZZZZ", "", true},
+		{"", "", true},
+	}
+	for _, tt := range tests {
+		got, err := detectCardFormat(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("detectCardFormat(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("detectCardFormat(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestFelicaAccessCode(t *testing.T) {
+	tests := []struct {
+		idm     string
+		want    string
+		wantErr bool
+	}{
+		{"0000000000000001", "00000000000000000001", false},
+		{"012E4CD8B1A2C3D4", "00085089936543499220", false},
+		{"FFFFFFFFFFFFFFFF", "18446744073709551615", false},
+		{"012E4CD8B1A2C3DZ", "", true},
+	}
+	for _, tt := range tests {
+		got, err := felicaAccessCode(tt.idm)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("felicaAccessCode(%q) error = %v, wantErr %v", tt.idm, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("felicaAccessCode(%q) = %q, want %q", tt.idm, got, tt.want)
+		}
+	}
+}
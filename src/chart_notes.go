@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// chartNotesFile is read by the TUI when the note count check is switched on
+const chartNotesFile = "chart_notes.json"
+
+// noteMismatchPolicy says what happens to plays whose judgements do not match
+// the chart's note count
+type noteMismatchPolicy string
+
+const (
+	noteMismatchAnnotate noteMismatchPolicy = "annotate"
+	noteMismatchDrop     noteMismatchPolicy = "drop"
+)
+
+func parseNoteMismatchPolicy(policy string) (noteMismatchPolicy, error) {
+	switch noteMismatchPolicy(policy) {
+	case "", noteMismatchAnnotate:
+		return noteMismatchAnnotate, nil
+	case noteMismatchDrop:
+		return noteMismatchDrop, nil
+	default:
+		return "", fmt.Errorf("unknown note mismatch policy %q (expected annotate or drop)", policy)
+	}
+}
+
+type chartNotes struct {
+	Notes int
+	Bells int
+}
+
+// chartNoteCounts maps a game key ("chunithm", "ongeki") to its charts
+type chartNoteCounts map[string]map[chartKey]chartNotes
+
+// loadChartNoteCounts reads one or more comma separated chart files. Each file
+// is a JSON array of charts, either in the simple form
+//
+//	{"game": "ongeki", "identifier": "123", "difficulty": "MASTER", "notes": 1024, "bells": 96}
+//
+// or as Tachi seed chart documents, where the game comes from a "game" field
+// or the file name (charts-chunithm.json) and the counts from the chart data.
+func loadChartNoteCounts(paths string) (chartNoteCounts, error) {
+	counts := make(chartNoteCounts)
+	for _, path := range splitList(paths) {
+		if err := counts.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("no note counts found in %s", paths)
+	}
+	return counts, nil
+}
+
+func (c chartNoteCounts) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read chart data: %w", err)
+	}
+
+	var charts []map[string]any
+	if err := json.Unmarshal(data, &charts); err != nil {
+		return fmt.Errorf("failed to parse chart data %s: %w", path, err)
+	}
+
+	fileGame := ""
+	base := strings.ToLower(filepath.Base(path))
+	for _, game := range []string{"chunithm", "ongeki"} {
+		if strings.Contains(base, game) {
+			fileGame = game
+		}
+	}
+
+	for _, chart := range charts {
+		game, _ := chart["game"].(string)
+		if game == "" {
+			game = fileGame
+		}
+		difficulty, _ := chart["difficulty"].(string)
+
+		// Tachi seeds keep the in-game ID and counts in the chart data
+		fields := chart
+		identifier := jsonNumberString(chart["identifier"])
+		if chartData, ok := chart["data"].(map[string]any); ok {
+			fields = chartData
+			if identifier == "" {
+				identifier = jsonNumberString(chartData["inGameID"])
+			}
+		}
+
+		notes := firstJSONInt(fields, "notes", "notecount", "noteCount", "totalNotes")
+		bells := firstJSONInt(fields, "bells", "bellCount", "totalBellCount")
+		if game == "" || identifier == "" || difficulty == "" || (notes == 0 && bells == 0) {
+			continue
+		}
+
+		if c[game] == nil {
+			c[game] = make(map[chartKey]chartNotes)
+		}
+		c[game][chartKey{identifier, difficulty}] = chartNotes{Notes: notes, Bells: bells}
+	}
+	return nil
+}
+
+func jsonNumberString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatInt(int64(v), 10)
+	default:
+		return ""
+	}
+}
+
+func firstJSONInt(fields map[string]any, keys ...string) int {
+	for _, key := range keys {
+		if v, ok := fields[key].(float64); ok {
+			return int(v)
+		}
+	}
+	return 0
+}
+
+// checkChuniNotes compares a play's judgements with the chart's note count
+func (c chartNoteCounts) checkChuniNotes(score BatchManualScoreChuni) []anomalyIssue {
+	chart, ok := c["chunithm"][chartKey{score.Identifier, score.Difficulty}]
+	if !ok || chart.Notes == 0 || score.Judgements == nil {
+		return nil
+	}
+
+	j := score.Judgements
+	if total := j.JCrit + j.Justice + j.Attack + j.Miss; total != chart.Notes {
+		return []anomalyIssue{{"note-count", fmt.Sprintf("%d judgements on a %d note chart", total, chart.Notes)}}
+	}
+	return nil
+}
+
+// checkGekiNotes compares a play's judgements and bells with the chart
+func (c chartNoteCounts) checkGekiNotes(score BatchManualScoreGeki) []anomalyIssue {
+	chart, ok := c["ongeki"][chartKey{score.Identifier, score.Difficulty}]
+	if !ok {
+		return nil
+	}
+
+	var issues []anomalyIssue
+	if j := score.Judgements; j != nil && chart.Notes > 0 {
+		if total := j.CBreak + j.Break + j.Hit + j.Miss; total != chart.Notes {
+			issues = append(issues, anomalyIssue{"note-count", fmt.Sprintf("%d judgements on a %d note chart", total, chart.Notes)})
+		}
+	}
+	if o := score.Optional; o != nil && chart.Bells > 0 {
+		if o.TotalBellCount != chart.Bells || o.BellCount > chart.Bells {
+			issues = append(issues, anomalyIssue{"bell-count", fmt.Sprintf("%d of %d bells on a %d bell chart", o.BellCount, o.TotalBellCount, chart.Bells)})
+		}
+	}
+	return issues
+}
+
+// screenNoteCounts applies the note mismatch policy to one play, annotating
+// it with a Tachi comment or dropping it. Mismatches go into the anomaly report.
+func screenNoteCounts(opts exportOptions, stats *exportStats, playlogID int64, identifier, difficulty string, issues []anomalyIssue) (string, bool) {
+	if len(issues) == 0 {
+		return "", true
+	}
+	stats.NoteMismatches++
+
+	policy, _ := parseNoteMismatchPolicy(string(opts.NoteMismatch))
+	action := "annotated"
+	if policy == noteMismatchDrop {
+		action = "dropped"
+	}
+	if opts.anomalies != nil {
+		opts.anomalies.Anomalies = append(opts.anomalies.Anomalies, anomaly{
+			PlaylogID:  playlogID,
+			Identifier: identifier,
+			Difficulty: difficulty,
+			Issues:     issues,
+			Action:     action,
+		})
+	}
+	if policy == noteMismatchDrop {
+		return "", false
+	}
+
+	var details []string
+	for _, issue := range issues {
+		details = append(details, issue.Detail)
+	}
+	return "Possibly recorded against the wrong difficulty: " + strings.Join(details, ", "), true
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	chuniBestFrameSize   = 30
+	chuniRecentFrameSize = 10
+	// The game keeps its recent frame internally, it is approximated here as the
+	// best plays among the most recent ones
+	chuniRecentCandidates = 30
+)
+
+type chuniChartKey struct {
+	MusicID    int64
+	Difficulty string
+}
+
+// chuniChartRating is the rating a single score is worth
+type chuniChartRating struct {
+	MusicID    int64
+	Difficulty string
+	Constant   float64
+	Score      int
+	Rating     float64
+}
+
+type chuniPlayerRating struct {
+	Rating float64
+	Best   []chuniChartRating
+	Recent []chuniChartRating
+}
+
+// loadChuniChartConstants reads chart constants from chuni_static_music.
+// Rows of newer versions override older ones.
+func loadChuniChartConstants(db *sql.DB) (map[chuniChartKey]float64, error) {
+	rows, err := db.Query("SELECT songId, chartId, level FROM chuni_static_music WHERE chartId < 5 ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chart constants: %w", err)
+	}
+	defer rows.Close()
+
+	difficulties := []string{"BASIC", "ADVANCED", "EXPERT", "MASTER", "ULTIMA"}
+	constants := make(map[chuniChartKey]float64)
+	for rows.Next() {
+		var songID, chartID sql.NullInt64
+		var level sql.NullFloat64
+		if err := rows.Scan(&songID, &chartID, &level); err != nil {
+			return nil, fmt.Errorf("failed to scan chart constant: %w", err)
+		}
+		if !songID.Valid || !chartID.Valid || !level.Valid || chartID.Int64 < 0 {
+			continue
+		}
+		constants[chuniChartKey{songID.Int64, difficulties[chartID.Int64]}] = level.Float64
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return constants, nil
+}
+
+// chuniPlayRating returns the rating of a score in hundredths, truncated the
+// same way the game does
+func chuniPlayRating(constant float64, score int) int {
+	c := int(constant*100 + 0.5)
+	s := score
+
+	var rating int
+	switch {
+	case s >= 1009000:
+		rating = c + 215
+	case s >= 1007500:
+		rating = c + 200 + (s-1007500)/100
+	case s >= 1005000:
+		rating = c + 150 + (s-1005000)/50
+	case s >= 1000000:
+		rating = c + 100 + (s-1000000)/100
+	case s >= 975000:
+		rating = c + (s-975000)/250
+	case s >= 925000:
+		rating = c - 300 + (s-925000)*300/50000
+	case s >= 900000:
+		rating = c - 500 + (s-900000)*200/25000
+	case s >= 800000:
+		rating = (c-500)/2 + (s-800000)*((c-500)/2)/100000
+	case s >= 500000:
+		rating = (s - 500000) * ((c - 500) / 2) / 300000
+	}
+
+	if rating < 0 {
+		return 0
+	}
+	return rating
+}
+
+// fetchChuniPlayerRating computes the overall rating from every playlog row of
+// the user: the best 30 charts plus the recent frame, averaged over 40
+func fetchChuniPlayerRating(db *sql.DB, userID string) (*chuniPlayerRating, error) {
+	constants, err := loadChuniChartConstants(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(constants) == 0 {
+		return nil, fmt.Errorf("no chart constants in chuni_static_music")
+	}
+
+	rows, err := db.Query("SELECT romVersion, musicId, level, score FROM chuni_score_playlog WHERE user = ? ORDER BY userPlayDate", userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch playlog: %w", err)
+	}
+	defer rows.Close()
+
+	best := make(map[chuniChartKey]chuniChartRating)
+	var plays []chuniChartRating
+	for rows.Next() {
+		var romVersionString sql.NullString
+		var musicID, level, score sql.NullInt64
+		if err := rows.Scan(&romVersionString, &musicID, &level, &score); err != nil {
+			return nil, fmt.Errorf("failed to scan playlog row: %w", err)
+		}
+		if !romVersionString.Valid || !musicID.Valid || !level.Valid || !score.Valid {
+			continue
+		}
+
+		romVersion, err := parseChuniRomVersion(romVersionString.String)
+		if err != nil {
+			continue
+		}
+		release := romVersion.release()
+		if release == nil {
+			continue
+		}
+		difficulty, ok := release.difficulty(level.Int64)
+		if !ok || difficulty == worldsEndDifficulty {
+			continue
+		}
+
+		key := chuniChartKey{musicID.Int64, difficulty}
+		constant, ok := constants[key]
+		if !ok {
+			continue
+		}
+
+		play := chuniChartRating{
+			MusicID:    musicID.Int64,
+			Difficulty: difficulty,
+			Constant:   constant,
+			Score:      int(score.Int64),
+			Rating:     float64(chuniPlayRating(constant, int(score.Int64))) / 100,
+		}
+		plays = append(plays, play)
+		if current, ok := best[key]; !ok || play.Score > current.Score {
+			best[key] = play
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var rating chuniPlayerRating
+	for _, play := range best {
+		rating.Best = append(rating.Best, play)
+	}
+	sortChuniRatings(rating.Best)
+	if len(rating.Best) > chuniBestFrameSize {
+		rating.Best = rating.Best[:chuniBestFrameSize]
+	}
+
+	if len(plays) > chuniRecentCandidates {
+		plays = plays[len(plays)-chuniRecentCandidates:]
+	}
+	rating.Recent = append(rating.Recent, plays...)
+	sortChuniRatings(rating.Recent)
+	if len(rating.Recent) > chuniRecentFrameSize {
+		rating.Recent = rating.Recent[:chuniRecentFrameSize]
+	}
+
+	// Sum in hundredths so the result truncates like the in-game value
+	var total int
+	for _, play := range rating.Best {
+		total += chuniPlayRating(play.Constant, play.Score)
+	}
+	for _, play := range rating.Recent {
+		total += chuniPlayRating(play.Constant, play.Score)
+	}
+	rating.Rating = float64(total/(chuniBestFrameSize+chuniRecentFrameSize)) / 100
+
+	return &rating, nil
+}
+
+func sortChuniRatings(ratings []chuniChartRating) {
+	sort.SliceStable(ratings, func(i, j int) bool { return ratings[i].Rating > ratings[j].Rating })
+}
+
+// getChuniTachiColour maps an overall rating to Tachi's colour class
+func getChuniTachiColour(rating float64) *string {
+	colours := []struct {
+		min    float64
+		colour string
+	}{
+		{16.00, "RAINBOW"},
+		{15.25, "PLATINUM"},
+		{14.50, "GOLD"},
+		{13.25, "SILVER"},
+		{12.00, "COPPER"},
+		{10.00, "PURPLE"},
+		{7.00, "RED"},
+		{4.00, "ORANGE"},
+		{2.00, "GREEN"},
+		{0, "BLUE"},
+	}
+	for _, c := range colours {
+		if rating >= c.min {
+			colour := c.colour
+			return &colour
+		}
+	}
+	return nil
+}
+
+// formatChuniRatingSummary lists the rating frames and what each chart contributes
+func formatChuniRatingSummary(rating *chuniPlayerRating) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Rating: %.2f\n", rating.Rating)
+
+	writeFrame := func(title string, plays []chuniChartRating) {
+		fmt.Fprintf(&b, "\n%s (%d charts)\n", title, len(plays))
+		for i, play := range plays {
+			fmt.Fprintf(&b, "%3d. %6d %-9s %5.1f  %7d  %6.2f\n", i+1, play.MusicID, play.Difficulty, play.Constant, play.Score, play.Rating)
+		}
+	}
+	writeFrame("Best frame", rating.Best)
+	writeFrame("Recent frame", rating.Recent)
+
+	return b.String()
+}
@@ -0,0 +1,40 @@
+package main
+
+import "testing"
+
+func TestGetChuniTachiColour(t *testing.T) {
+	tests := []struct {
+		rating float64
+		want   string
+	}{
+		{0, "BLUE"},
+		{1.99, "BLUE"},
+		{2.00, "GREEN"},
+		{3.99, "GREEN"},
+		{4.00, "ORANGE"},
+		{6.99, "ORANGE"},
+		{7.00, "RED"},
+		{9.99, "RED"},
+		{10.00, "PURPLE"},
+		{11.99, "PURPLE"},
+		{12.00, "COPPER"},
+		{13.24, "COPPER"},
+		{13.25, "SILVER"},
+		{14.49, "SILVER"},
+		{14.50, "GOLD"},
+		{15.24, "GOLD"},
+		{15.25, "PLATINUM"},
+		{15.99, "PLATINUM"},
+		{16.00, "RAINBOW"},
+		{17.50, "RAINBOW"},
+	}
+	for _, tt := range tests {
+		got := getChuniTachiColour(tt.rating)
+		if got == nil || *got != tt.want {
+			t.Errorf("getChuniTachiColour(%.2f) = %v, want %s", tt.rating, got, tt.want)
+		}
+	}
+	if got := getChuniTachiColour(-1); got != nil {
+		t.Errorf("getChuniTachiColour(-1) = %s, want nil", *got)
+	}
+}
@@ -2,8 +2,8 @@ package main
 
 import (
 	"database/sql"
-	"encoding/json"
 	"fmt"
+	"log"
 	"os"
 	"time"
 )
@@ -34,6 +34,17 @@ type BatchManualScoreChuni struct {
 	Optional *struct {
 		MaxCombo int `json:"maxCombo"`
 	} `json:"optional,omitempty"`
+
+	// Comment is shown on Tachi, only set to flag suspicious plays
+	Comment string `json:"comment,omitempty"`
+
+	// PlaylogID points anomaly reports back at the database row
+	PlaylogID int64 `json:"-"`
+	// RomVersion and RawJudgements are only written to the CSV and NDJSON
+	// exports. RawJudgements holds the playlog's judgeHeaven, judgeCritical,
+	// judgeJustice, judgeAttack and judgeGuilty, which Tachi's jcrit merges.
+	RomVersion    string  `json:"-"`
+	RawJudgements *[5]int `json:"-"`
 }
 
 type BatchManualImportChuni struct {
@@ -41,33 +52,107 @@ type BatchManualImportChuni struct {
 		Game     string `json:"game"`
 		Playtype string `json:"playtype"`
 		Service  string `json:"service"`
+		Version  string `json:"version,omitempty"`
 	} `json:"meta"`
 	Scores  []BatchManualScoreChuni `json:"scores"`
-	Classes *struct {
-		Dan    *string `json:"dan,omitempty"`
-		Emblem *string `json:"emblem,omitempty"`
-	} `json:"classes,omitempty"`
+	Classes *chuniClasses           `json:"classes,omitempty"`
+
+	// WORLD'S END plays are kept aside for a separate archive, never sent to Tachi
+	WorldsEnd []worldsEndScore `json:"-"`
+}
+
+type chuniClasses struct {
+	Dan    *string `json:"dan,omitempty"`
+	Emblem *string `json:"emblem,omitempty"`
+	Colour *string `json:"colour,omitempty"`
 }
 
-func fetchChuniTachiExport(db *sql.DB, userID string) (*BatchManualImportChuni, error) {
+// newChuniTachiExport returns an export with only its meta filled in
+func newChuniTachiExport(opts exportOptions) *BatchManualImportChuni {
 	var tachiExport BatchManualImportChuni
+	tachiExport.Meta.Game = "chunithm"
+	tachiExport.Meta.Playtype = "Single"
+	tachiExport.Meta.Service = "Cozynet"
+	tachiExport.Meta.Version = opts.Version
+	return &tachiExport
+}
+
+func fetchChuniTachiExport(db *sql.DB, userID string, opts exportOptions) (*BatchManualImportChuni, exportStats, error) {
+	tachiExport := newChuniTachiExport(opts)
 
-	// Fetch profile data
-	var classEmblemBase, classEmblemMedal int
-	err := db.QueryRow("SELECT classEmblemBase, classEmblemMedal FROM chuni_profile_data WHERE user = ?", userID).Scan(&classEmblemBase, &classEmblemMedal)
+	emblems, err := fetchChuniClassEmblems(db, userID)
 	if err != nil {
-		return nil, err
+		return nil, exportStats{}, err
 	}
 
-	// Fetch playlog data
-	rows, err := db.Query("SELECT romVersion, userPlayDate, musicId, level, score, maxCombo, judgeGuilty, judgeAttack, judgeJustice, judgeCritical, judgeHeaven, isFullCombo, isAllJustice, isClear FROM chuni_score_playlog WHERE user = ?", userID)
+	worldsEnd, stats, err := scanChuniPlaylog(db, userID, opts, func(score BatchManualScoreChuni) error {
+		tachiExport.Scores = append(tachiExport.Scores, score)
+		return nil
+	})
 	if err != nil {
-		return nil, err
+		return nil, stats, err
+	}
+	tachiExport.WorldsEnd = worldsEnd
+	tachiExport.Classes = fetchChuniClasses(db, userID, emblems)
+
+	return tachiExport, stats, nil
+}
+
+// fetchChuniClassEmblems reads the dan and emblem from the profile, which also
+// makes sure the user has played Chunithm before anything is written
+func fetchChuniClassEmblems(db *sql.DB, userID string) ([2]int, error) {
+	var emblems [2]int
+	err := db.QueryRow("SELECT classEmblemBase, classEmblemMedal FROM chuni_profile_data WHERE user = ?", userID).Scan(&emblems[0], &emblems[1])
+	return emblems, err
+}
+
+func fetchChuniClasses(db *sql.DB, userID string, emblems [2]int) *chuniClasses {
+	classes := &chuniClasses{
+		Dan:    getChuniTachiClass(emblems[0]),
+		Emblem: getChuniTachiClass(emblems[1]),
+	}
+
+	// The colour class needs chart constants, servers without static music data skip it
+	rating, err := fetchChuniPlayerRating(db, userID)
+	if err != nil {
+		log.Printf("Skipping colour class: %v", err)
+	} else {
+		classes.Colour = getChuniTachiColour(rating.Rating)
+	}
+	return classes
+}
+
+// scanChuniPlaylog reads the playlog row by row and hands each exported score
+// to emit right away. Best-only exports are emitted once all rows are read.
+// WORLD'S END plays are returned for the separate archive.
+func scanChuniPlaylog(db *sql.DB, userID string, opts exportOptions, emit func(BatchManualScoreChuni) error) ([]worldsEndScore, exportStats, error) {
+	var worldsEnd []worldsEndScore
+	var stats exportStats
+
+	var bests *bestCollector[BatchManualScoreChuni]
+	if opts.BestOnly {
+		bests = newBestCollector[BatchManualScoreChuni]()
+	}
+
+	// send drops scores that were exported before when deduplicating
+	send := func(score BatchManualScoreChuni) error {
+		if opts.sent != nil && opts.sent.check(score.dedupeHash()) {
+			stats.AlreadySent++
+			return nil
+		}
+		return emit(score)
+	}
+
+	now := time.Now()
+	rows, err := db.Query("SELECT id, romVersion, userPlayDate, musicId, level, score, maxCombo, judgeGuilty, judgeAttack, judgeJustice, judgeCritical, judgeHeaven, isFullCombo, isAllJustice, isClear FROM chuni_score_playlog WHERE user = ?", userID)
+	if err != nil {
+		return nil, stats, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var playlog struct {
+			ID            int64
 			RomVersion    sql.NullString
 			UserPlayDate  sql.NullString
 			MusicID       sql.NullInt64
@@ -84,19 +169,35 @@ func fetchChuniTachiExport(db *sql.DB, userID string) (*BatchManualImportChuni,
 			IsClear       sql.NullBool
 		}
 
-		err := rows.Scan(&playlog.RomVersion, &playlog.UserPlayDate, &playlog.MusicID, &playlog.Level, &playlog.Score, &playlog.MaxCombo, &playlog.JudgeGuilty, &playlog.JudgeAttack, &playlog.JudgeJustice, &playlog.JudgeCritical, &playlog.JudgeHeaven, &playlog.IsFullCombo, &playlog.IsAllJustice, &playlog.IsClear)
+		err := rows.Scan(&playlog.ID, &playlog.RomVersion, &playlog.UserPlayDate, &playlog.MusicID, &playlog.Level, &playlog.Score, &playlog.MaxCombo, &playlog.JudgeGuilty, &playlog.JudgeAttack, &playlog.JudgeJustice, &playlog.JudgeCritical, &playlog.JudgeHeaven, &playlog.IsFullCombo, &playlog.IsAllJustice, &playlog.IsClear)
 		if err != nil {
-			return nil, err
+			return nil, stats, err
 		}
 
 		if !playlog.RomVersion.Valid || !playlog.MusicID.Valid || !playlog.Level.Valid || !playlog.Score.Valid || !playlog.JudgeJustice.Valid || !playlog.IsAllJustice.Valid || !playlog.IsFullCombo.Valid || !playlog.IsClear.Valid {
 			continue
 		}
 
-		// Filter out WORLD'S END scores
-		if (playlog.RomVersion.String[:2] == "1." && playlog.Level.Int64 == 4) || (playlog.RomVersion.String[:2] == "2." && playlog.Level.Int64 == 5) {
+		romVersion, err := parseChuniRomVersion(playlog.RomVersion.String)
+		if err != nil {
 			continue
 		}
+		release := romVersion.release()
+		if release == nil {
+			continue
+		}
+		difficulty, ok := release.difficulty(playlog.Level.Int64)
+		if !ok {
+			continue
+		}
+
+		var playDate *time.Time
+		if playlog.UserPlayDate.Valid {
+			parsed, err := time.Parse("2006-01-02 15:04:05", playlog.UserPlayDate.String)
+			if err == nil {
+				playDate = &parsed
+			}
+		}
 
 		var lamp BatchManualLampChuni
 		if playlog.IsAllJustice.Bool && playlog.JudgeJustice.Int64 == 0 {
@@ -111,20 +212,60 @@ func fetchChuniTachiExport(db *sql.DB, userID string) (*BatchManualImportChuni,
 			lamp = Failed
 		}
 
+		// Filter out WORLD'S END scores, Tachi does not track them
+		if difficulty == worldsEndDifficulty {
+			if opts.WorldsEnd != "" && opts.Filter.allowsPlay(playDate, difficulty, playlog.MusicID.Int64, playlog.RomVersion.String) {
+				weScore := worldsEndScore{
+					MusicID:    playlog.MusicID.Int64,
+					Score:      int(playlog.Score.Int64),
+					Lamp:       lamp,
+					RomVersion: playlog.RomVersion.String,
+					JCrit:      int(playlog.JudgeHeaven.Int64 + playlog.JudgeCritical.Int64),
+					Justice:    int(playlog.JudgeJustice.Int64),
+					Attack:     int(playlog.JudgeAttack.Int64),
+					Miss:       int(playlog.JudgeGuilty.Int64),
+					MaxCombo:   int(playlog.MaxCombo.Int64),
+				}
+				if playDate != nil {
+					weScore.TimeAchieved = new(int64)
+					*weScore.TimeAchieved = playDate.Unix()
+				}
+				worldsEnd = append(worldsEnd, weScore)
+				stats.WorldsEnd++
+			}
+			continue
+		}
+
+		// Version-scoped exports only carry plays from that release
+		if opts.Version != "" && release.TachiID != opts.Version {
+			stats.Filtered++
+			continue
+		}
+
+		if !opts.Filter.allowsPlay(playDate, difficulty, playlog.MusicID.Int64, playlog.RomVersion.String) {
+			stats.Filtered++
+			continue
+		}
+
+		// Omnimix and custom charts could be matched to a different song on Tachi
+		if opts.Rules.excludes("chunithm", playlog.MusicID.Int64) {
+			stats.CustomExcluded++
+			continue
+		}
+
 		tachiScore := BatchManualScoreChuni{
 			Identifier: fmt.Sprintf("%d", playlog.MusicID.Int64),
 			MatchType:  "inGameID",
 			Score:      int(playlog.Score.Int64),
 			Lamp:       lamp,
-			Difficulty: []string{"BASIC", "ADVANCED", "EXPERT", "MASTER", "ULTIMA"}[playlog.Level.Int64],
+			Difficulty: difficulty,
+			PlaylogID:  playlog.ID,
+			RomVersion: playlog.RomVersion.String,
 		}
 
-		if playlog.UserPlayDate.Valid {
-			playDate, err := time.Parse("2006-01-02 15:04:05", playlog.UserPlayDate.String)
-			if err == nil {
-				tachiScore.TimeAchieved = new(int64)
-				*tachiScore.TimeAchieved = playDate.Unix()
-			}
+		if playDate != nil {
+			tachiScore.TimeAchieved = new(int64)
+			*tachiScore.TimeAchieved = playDate.Unix()
 		}
 
 		if playlog.JudgeCritical.Valid && playlog.JudgeJustice.Valid && playlog.JudgeAttack.Valid && playlog.JudgeGuilty.Valid {
@@ -139,6 +280,10 @@ func fetchChuniTachiExport(db *sql.DB, userID string) (*BatchManualImportChuni,
 				Attack:  int(playlog.JudgeAttack.Int64),
 				Miss:    int(playlog.JudgeGuilty.Int64),
 			}
+			tachiScore.RawJudgements = &[5]int{
+				int(playlog.JudgeHeaven.Int64), int(playlog.JudgeCritical.Int64), int(playlog.JudgeJustice.Int64),
+				int(playlog.JudgeAttack.Int64), int(playlog.JudgeGuilty.Int64),
+			}
 		}
 
 		if playlog.MaxCombo.Valid {
@@ -149,22 +294,45 @@ func fetchChuniTachiExport(db *sql.DB, userID string) (*BatchManualImportChuni,
 			}
 		}
 
-		tachiExport.Scores = append(tachiExport.Scores, tachiScore)
-	}
+		// Corrupt rows are excluded, fixed or kept as the anomaly policy says
+		issues, fixed := validateChuniScore(tachiScore, now)
+		tachiScore, ok = screenScore(opts, &stats, playlog.ID, tachiScore.Identifier, difficulty, issues, tachiScore, fixed)
+		if !ok {
+			continue
+		}
+		if opts.ChartNotes != nil {
+			tachiScore.Comment, ok = screenNoteCounts(opts, &stats, playlog.ID, tachiScore.Identifier, difficulty, opts.ChartNotes.checkChuniNotes(tachiScore))
+			if !ok {
+				continue
+			}
+		}
 
-	tachiExport.Meta.Game = "chunithm"
-	tachiExport.Meta.Playtype = "Single"
-	tachiExport.Meta.Service = "Cozynet"
+		stats.Exported++
+		if bests != nil {
+			bests.add(tachiScore)
+			continue
+		}
+		if err := send(tachiScore); err != nil {
+			return nil, stats, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, stats, err
+	}
 
-	tachiExport.Classes = &struct {
-		Dan    *string `json:"dan,omitempty"`
-		Emblem *string `json:"emblem,omitempty"`
-	}{
-		Dan:    getChuniTachiClass(classEmblemBase),
-		Emblem: getChuniTachiClass(classEmblemMedal),
+	if bests != nil {
+		collapsed := bests.result()
+		for _, score := range collapsed {
+			if err := send(score); err != nil {
+				return nil, stats, err
+			}
+		}
+		stats.Collapsed = stats.Exported - len(collapsed)
+		stats.Exported = len(collapsed)
 	}
+	stats.Exported -= stats.AlreadySent
 
-	return &tachiExport, nil
+	return worldsEnd, stats, nil
 }
 
 func getChuniTachiClass(class int) *string {
@@ -175,19 +343,36 @@ func getChuniTachiClass(class int) *string {
 	return nil
 }
 
-func exportChuniToTachi(tachiExport *BatchManualImportChuni) error {
+// exportChuniToTachi streams the export straight from the playlog into
+// exports/chuni_tachi_export.json, so memory use does not grow with the
+// number of plays. It returns the WORLD'S END plays for the archive.
+func exportChuniToTachi(db *sql.DB, userID string, opts exportOptions) ([]worldsEndScore, exportStats, error) {
+	tachiExport := newChuniTachiExport(opts)
+
+	emblems, err := fetchChuniClassEmblems(db, userID)
+	if err != nil {
+		return nil, exportStats{}, err
+	}
+
 	if err := os.MkdirAll("exports", 0755); err != nil {
-		return fmt.Errorf("failed to create exports directory: %w", err)
+		return nil, exportStats{}, fmt.Errorf("failed to create exports directory: %w", err)
 	}
 
-	file, err := json.MarshalIndent(tachiExport, "", " ")
+	stream, err := createBatchManualStream("exports/chuni_tachi_export.json", tachiExport.Meta)
 	if err != nil {
-		return fmt.Errorf("failed to marshal export data: %w", err)
+		return nil, exportStats{}, err
 	}
 
-	if err := os.WriteFile("exports/chuni_tachi_export.json", file, 0644); err != nil {
-		return fmt.Errorf("failed to write export file: %w", err)
+	worldsEnd, stats, err := scanChuniPlaylog(db, userID, opts, func(score BatchManualScoreChuni) error {
+		return stream.writeScore(score)
+	})
+	if err != nil {
+		stream.abort()
+		return nil, stats, err
 	}
 
-	return nil
+	if err := stream.finish(fetchChuniClasses(db, userID, emblems)); err != nil {
+		return nil, stats, err
+	}
+	return worldsEnd, stats, nil
 }
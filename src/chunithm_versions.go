@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// chuniRomVersion is a parsed playlog romVersion such as "2.15.00"
+type chuniRomVersion struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+// chuniRelease describes a named Chunithm release and how its playlogs number
+// the difficulties
+type chuniRelease struct {
+	Name    string
+	TachiID string   // version name used by Tachi, empty when Tachi has no such version
+	Aliases []string // other version names accepted for the same Tachi version
+	Major   int
+	Minor   int
+	// Ultima is false for 1.x releases, where level 4 is WORLD'S END
+	Ultima bool
+}
+
+// chuniReleases is ordered by rom version. A rom version belongs to the last
+// release at or below it, so minor updates map to their parent release.
+var chuniReleases = []chuniRelease{
+	{Name: "CHUNITHM", Major: 1, Minor: 0},
+	{Name: "PLUS", Major: 1, Minor: 5},
+	{Name: "AIR", Major: 1, Minor: 10},
+	{Name: "AIR PLUS", Major: 1, Minor: 15},
+	{Name: "STAR", Major: 1, Minor: 20},
+	{Name: "STAR PLUS", Major: 1, Minor: 25},
+	{Name: "AMAZON", Major: 1, Minor: 30},
+	{Name: "AMAZON PLUS", Major: 1, Minor: 35},
+	{Name: "CRYSTAL", Major: 1, Minor: 40},
+	{Name: "CRYSTAL PLUS", Major: 1, Minor: 45},
+	// Tachi files PARADISE and its PARADISE LOST update (1.55) under one version
+	{Name: "PARADISE", TachiID: "paradiselost", Aliases: []string{"paradise"}, Major: 1, Minor: 50},
+	{Name: "NEW", TachiID: "new", Major: 2, Minor: 0, Ultima: true},
+	{Name: "NEW PLUS", TachiID: "newplus", Major: 2, Minor: 5, Ultima: true},
+	{Name: "SUN", TachiID: "sun", Major: 2, Minor: 10, Ultima: true},
+	{Name: "SUN PLUS", TachiID: "sunplus", Major: 2, Minor: 15, Ultima: true},
+	{Name: "LUMINOUS", TachiID: "luminous", Major: 2, Minor: 20, Ultima: true},
+	{Name: "LUMINOUS PLUS", TachiID: "luminousplus", Major: 2, Minor: 25, Ultima: true},
+	{Name: "VERSE", TachiID: "verse", Major: 2, Minor: 30, Ultima: true},
+}
+
+// parseChuniRomVersion parses "major.minor[.patch]". Short or malformed
+// strings are rejected instead of being sliced blindly.
+func parseChuniRomVersion(rom string) (chuniRomVersion, error) {
+	var version chuniRomVersion
+
+	parts := strings.Split(strings.TrimSpace(rom), ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return version, fmt.Errorf("invalid rom version %q", rom)
+	}
+
+	numbers := make([]int, 3)
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return version, fmt.Errorf("invalid rom version %q", rom)
+		}
+		numbers[i] = n
+	}
+
+	version.Major, version.Minor, version.Patch = numbers[0], numbers[1], numbers[2]
+	return version, nil
+}
+
+func (v chuniRomVersion) String() string {
+	return fmt.Sprintf("%d.%02d.%02d", v.Major, v.Minor, v.Patch)
+}
+
+// release returns the named release this rom version belongs to, or nil when
+// it predates every known release
+func (v chuniRomVersion) release() *chuniRelease {
+	var found *chuniRelease
+	for i := range chuniReleases {
+		r := &chuniReleases[i]
+		if r.Major < v.Major || (r.Major == v.Major && r.Minor <= v.Minor) {
+			found = r
+		}
+	}
+	return found
+}
+
+// difficulty maps a playlog level to its difficulty name for this release.
+// ok is false for levels the release does not have.
+func (r *chuniRelease) difficulty(level int64) (name string, ok bool) {
+	switch {
+	case level >= 0 && level <= 3:
+		return []string{"BASIC", "ADVANCED", "EXPERT", "MASTER"}[level], true
+	case level == 4 && r.Ultima:
+		return "ULTIMA", true
+	case level == 4 && !r.Ultima, level == 5 && r.Ultima:
+		return worldsEndDifficulty, true
+	}
+	return "", false
+}
+
+// chuniReleaseByTachiID looks up a release by its Tachi version name or one
+// of its aliases
+func chuniReleaseByTachiID(id string) (*chuniRelease, error) {
+	var known []string
+	for i := range chuniReleases {
+		if chuniReleases[i].TachiID == "" {
+			continue
+		}
+		if chuniReleases[i].TachiID == id || slices.Contains(chuniReleases[i].Aliases, id) {
+			return &chuniReleases[i], nil
+		}
+		known = append(known, chuniReleases[i].TachiID)
+	}
+	return nil, fmt.Errorf("unknown Chunithm version %q (expected one of %s)", id, strings.Join(known, ", "))
+}
+
+// nextChuniTachiVersion returns the Tachi version name after current, wrapping
+// back to "" (all versions) after the last one
+func nextChuniTachiVersion(current string) string {
+	found := current == ""
+	for _, r := range chuniReleases {
+		if r.TachiID == "" {
+			continue
+		}
+		if found {
+			return r.TachiID
+		}
+		found = r.TachiID == current
+	}
+	return ""
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+const worldsEndDifficulty = "WORLD'S END"
+
+// worldsEndScore is a single WORLD'S END play. Tachi has no charts for these,
+// so they are written to their own archive instead of the batch-manual file.
+type worldsEndScore struct {
+	MusicID      int64                `json:"musicId"`
+	Title        string               `json:"title,omitempty"`
+	Stars        float64              `json:"stars,omitempty"`
+	Type         string               `json:"type,omitempty"`
+	Score        int                  `json:"score"`
+	Lamp         BatchManualLampChuni `json:"lamp"`
+	RomVersion   string               `json:"romVersion"`
+	TimeAchieved *int64               `json:"timeAchieved,omitempty"`
+	JCrit        int                  `json:"jcrit"`
+	Justice      int                  `json:"justice"`
+	Attack       int                  `json:"attack"`
+	Miss         int                  `json:"miss"`
+	MaxCombo     int                  `json:"maxCombo"`
+}
+
+// parseWorldsEndFormat validates the archive format option, "" disables the archive
+func parseWorldsEndFormat(format string) (string, error) {
+	switch format {
+	case "", "json", "csv":
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown WORLD'S END archive format %q (expected json or csv)", format)
+	}
+}
+
+// fillWorldsEndChartInfo adds the star rating and type from chuni_static_music.
+// Servers without imported static data simply get archives without them.
+func fillWorldsEndChartInfo(db *sql.DB, scores []worldsEndScore) {
+	type chartInfo struct {
+		title string
+		stars float64
+		kind  string
+	}
+	charts := make(map[int64]*chartInfo)
+
+	for i := range scores {
+		info, seen := charts[scores[i].MusicID]
+		if !seen {
+			var title, kind sql.NullString
+			var stars sql.NullFloat64
+			err := db.QueryRow("SELECT title, level, worldsEndTag FROM chuni_static_music WHERE songId = ? AND chartId = 5 ORDER BY version DESC LIMIT 1", scores[i].MusicID).Scan(&title, &stars, &kind)
+			if err != nil {
+				if err != sql.ErrNoRows {
+					log.Printf("Could not read WORLD'S END static data: %v", err)
+					return
+				}
+			} else {
+				info = &chartInfo{title: title.String, stars: stars.Float64, kind: kind.String}
+			}
+			charts[scores[i].MusicID] = info
+		}
+
+		if info != nil {
+			scores[i].Title = info.title
+			scores[i].Stars = info.stars
+			scores[i].Type = info.kind
+		}
+	}
+}
+
+func exportChuniWorldsEnd(db *sql.DB, scores []worldsEndScore, format string) (string, error) {
+	if err := os.MkdirAll("exports", 0755); err != nil {
+		return "", fmt.Errorf("failed to create exports directory: %w", err)
+	}
+
+	fillWorldsEndChartInfo(db, scores)
+
+	path := "exports/chuni_worldsend_export." + format
+	switch format {
+	case "json":
+		if scores == nil {
+			scores = []worldsEndScore{}
+		}
+		file, err := json.MarshalIndent(scores, "", " ")
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal WORLD'S END data: %w", err)
+		}
+		if err := os.WriteFile(path, file, 0644); err != nil {
+			return "", fmt.Errorf("failed to write WORLD'S END file: %w", err)
+		}
+
+	case "csv":
+		file, err := os.Create(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to create WORLD'S END file: %w", err)
+		}
+		defer file.Close()
+
+		w := csv.NewWriter(file)
+		w.Write([]string{"musicId", "title", "stars", "type", "score", "lamp", "romVersion", "timeAchieved", "jcrit", "justice", "attack", "miss", "maxCombo"})
+		for _, score := range scores {
+			timeAchieved := ""
+			if score.TimeAchieved != nil {
+				timeAchieved = strconv.FormatInt(*score.TimeAchieved, 10)
+			}
+			stars := ""
+			if score.Stars != 0 {
+				stars = strconv.FormatFloat(score.Stars, 'f', -1, 64)
+			}
+			w.Write([]string{
+				strconv.FormatInt(score.MusicID, 10), score.Title, stars, score.Type,
+				strconv.Itoa(score.Score), string(score.Lamp), score.RomVersion, timeAchieved,
+				strconv.Itoa(score.JCrit), strconv.Itoa(score.Justice), strconv.Itoa(score.Attack),
+				strconv.Itoa(score.Miss), strconv.Itoa(score.MaxCombo),
+			})
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return "", fmt.Errorf("failed to write WORLD'S END file: %w", err)
+		}
+
+	default:
+		return "", fmt.Errorf("unknown WORLD'S END archive format %q", format)
+	}
+
+	return path, nil
+}
@@ -0,0 +1,657 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+const cliUsage = `Usage: artemis2tachi [command] [flags]
+
+Without a command the interactive TUI is started.
+
+Commands:
+  export       Export a user's scores to a Tachi batch-manual file
+  rating       Show a user's rating with its best and recent frames
+  report       Render a self-contained HTML page of a user's records
+  diff         Compare two batch-manual exports of the same player
+  import       Write a batch-manual file or Tachi score export into Artemis bests
+  history      List past exports, open one or generate it again
+  bundle       Save all of a user's Artemis data to a portable archive
+  restore      Import a bundle into another Artemis database as a new user
+  merge        Move the scores and cards of one user to another
+  leaderboard  Rank all players of a chart and list the week's top improvements
+
+Run "artemis2tachi <command> -h" for the flags of a command.
+`
+
+// runCLI dispatches the non-interactive commands
+func runCLI(args []string) error {
+	switch args[0] {
+	case "export":
+		return runExportCommand(args[1:])
+	case "rating":
+		return runRatingCommand(args[1:])
+	case "report":
+		return runReportCommand(args[1:])
+	case "diff":
+		return runDiffCommand(args[1:])
+	case "import":
+		return runImportCommand(args[1:])
+	case "history":
+		return runHistoryCommand(args[1:])
+	case "bundle":
+		return runBundleCommand(args[1:])
+	case "restore":
+		return runRestoreCommand(args[1:])
+	case "merge":
+		return runMergeCommand(args[1:])
+	case "leaderboard":
+		return runLeaderboardCommand(args[1:])
+	case "help", "-h", "-help", "--help":
+		fmt.Print(cliUsage)
+		return nil
+	default:
+		fmt.Fprint(os.Stderr, cliUsage)
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// filterFlags registers the export filter flags on a flag set
+type filterFlags struct {
+	from, to, difficulty, romMin, romMax, include, exclude *string
+}
+
+func addFilterFlags(fs *flag.FlagSet) filterFlags {
+	return filterFlags{
+		from:       fs.String("from", "", "only export plays on or after this date (YYYY-MM-DD)"),
+		to:         fs.String("to", "", "only export plays on or before this date (YYYY-MM-DD)"),
+		difficulty: fs.String("difficulty", "", "comma separated difficulties to export, e.g. MASTER,ULTIMA"),
+		romMin:     fs.String("rom-min", "", "minimum Chunithm romVersion, e.g. 2.00"),
+		romMax:     fs.String("rom-max", "", "maximum Chunithm romVersion, e.g. 2.15"),
+		include:    fs.String("include", "", "comma separated music IDs to export exclusively"),
+		exclude:    fs.String("exclude", "", "comma separated music IDs to leave out"),
+	}
+}
+
+func (f filterFlags) build() (exportFilter, error) {
+	return newExportFilter(*f.from, *f.to, *f.difficulty, *f.romMin, *f.romMax, *f.include, *f.exclude)
+}
+
+// userFlags registers the flags selecting a user, either by one of their Aime
+// cards or directly by user ID
+type userFlags struct {
+	card, aimeFile, user *string
+}
+
+func addUserFlags(fs *flag.FlagSet) userFlags {
+	return userFlags{
+		card:     fs.String("card", "", "Aime card of the user: 20 digit access code, FeliCa IDm or chip ID"),
+		aimeFile: fs.String("aime-file", "", "read the card from a segatools aime.txt or the aimePath of a segatools.ini"),
+		user:     fs.String("user", "", "Artemis user ID, instead of -card"),
+	}
+}
+
+func (f userFlags) isSet() bool {
+	return *f.card != "" || *f.aimeFile != "" || *f.user != ""
+}
+
+// resolve returns the user ID and the card it was found through, which is
+// empty when the user was given directly
+func (f userFlags) resolve(db *sql.DB) (string, string, error) {
+	set := 0
+	for _, value := range []string{*f.card, *f.aimeFile, *f.user} {
+		if value != "" {
+			set++
+		}
+	}
+	if set > 1 {
+		return "", "", fmt.Errorf("only one of -card, -aime-file and -user can be used")
+	}
+	if *f.user != "" {
+		if err := userExists(db, *f.user); err != nil {
+			return "", "", err
+		}
+		return *f.user, "", nil
+	}
+	card := *f.card
+	if *f.aimeFile != "" {
+		code, err := readSegatoolsAccessCode(*f.aimeFile)
+		if err != nil {
+			return "", "", err
+		}
+		card = code
+	}
+
+	lookup, err := lookupAimeCard(db, card)
+	if err != nil {
+		return "", "", err
+	}
+	log.Printf("Found user %s by %s", lookup.UserID, lookup)
+	return lookup.UserID, lookup.AccessCode, nil
+}
+
+// parseGameName maps a game given on the command line to the name used in the TUI
+func parseGameName(name string) (string, error) {
+	switch strings.ToLower(name) {
+	case "chunithm", "chuni":
+		return "Chunithm", nil
+	case "ongeki", "geki":
+		return "Ongeki", nil
+	case "maimai", "mai2":
+		return "MaiMai", nil
+	case "wacca":
+		return "WACCA", nil
+	default:
+		return "", fmt.Errorf("unknown game %q", name)
+	}
+}
+
+func runExportCommand(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	game := fs.String("game", "", "game to export (chunithm, ongeki, wacca)")
+	users := addUserFlags(fs)
+	rulesPath := fs.String("rules", "", "custom chart exclusion rules file (default exclusion_rules.json or built-in rules)")
+	worldsEnd := fs.String("worlds-end", "", "also archive Chunithm WORLD'S END plays as json or csv")
+	bestOnly := fs.Bool("best-only", false, "only export each chart's best score and lamp")
+	version := fs.String("version", "", "only export plays from this Chunithm version and scope the export to it, e.g. sunplus")
+	maxScores := fs.Int("max-scores", 0, "split the export into parts of at most this many scores")
+	maxBytes := fs.String("max-bytes", "", "split the export into parts of at most this size, e.g. 5MB")
+	skipSent := fs.Bool("skip-sent", false, "leave out scores an earlier -skip-sent export already contained")
+	sentState := fs.String("sent-state", defaultSentStatePath, "file remembering the scores already exported")
+	anomalies := fs.String("anomalies", "exclude", "what to do with corrupt plays: exclude, fix or keep")
+	charts := fs.String("charts", "", "comma separated chart data files (note count lists or Tachi seeds) to check judgements against")
+	format := fs.String("format", "tachi", "output format: tachi (batch-manual JSON), csv or ndjson")
+	noteMismatch := fs.String("note-mismatch", "annotate", "what to do with plays not matching the chart's note count: annotate or drop")
+	filters := addFilterFlags(fs)
+	fs.Parse(args)
+
+	if *game == "" || !users.isSet() {
+		fs.Usage()
+		return fmt.Errorf("-game and one of -card, -aime-file or -user are required")
+	}
+
+	gameName, err := parseGameName(*game)
+	if err != nil {
+		return err
+	}
+
+	filter, err := filters.build()
+	if err != nil {
+		return err
+	}
+
+	rules, err := loadExclusionRules(*rulesPath)
+	if err != nil {
+		return err
+	}
+
+	worldsEndFormat, err := parseWorldsEndFormat(*worldsEnd)
+	if err != nil {
+		return err
+	}
+
+	if *version != "" {
+		if _, err := chuniReleaseByTachiID(*version); err != nil {
+			return err
+		}
+	}
+
+	policy, err := parseAnomalyPolicy(*anomalies)
+	if err != nil {
+		return err
+	}
+
+	mismatchPolicy, err := parseNoteMismatchPolicy(*noteMismatch)
+	if err != nil {
+		return err
+	}
+	outputFormat, err := parseExportFormat(*format)
+	if err != nil {
+		return err
+	}
+	var chartNotes chartNoteCounts
+	if *charts != "" {
+		if chartNotes, err = loadChartNoteCounts(*charts); err != nil {
+			return err
+		}
+	}
+
+	chunkBytes, err := parseByteSize(*maxBytes)
+	if err != nil {
+		return err
+	}
+	if *maxScores < 0 {
+		return fmt.Errorf("-max-scores cannot be negative")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	userID, card, err := users.resolve(db)
+	if err != nil {
+		return err
+	}
+
+	result, err := exportForGame(db, gameName, userID, exportOptions{
+		Filter:         filter,
+		Rules:          rules,
+		RulesPath:      *rulesPath,
+		WorldsEnd:      worldsEndFormat,
+		Version:        *version,
+		BestOnly:       *bestOnly,
+		Chunks:         chunkLimits{MaxScores: *maxScores, MaxBytes: chunkBytes},
+		SkipSent:       *skipSent,
+		SentState:      *sentState,
+		Card:           card,
+		AnomalyPolicy:  policy,
+		ChartNotes:     chartNotes,
+		ChartNotesPath: *charts,
+		NoteMismatch:   mismatchPolicy,
+		Format:         outputFormat,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Println(result)
+	return nil
+}
+
+func runRatingCommand(args []string) error {
+	fs := flag.NewFlagSet("rating", flag.ExitOnError)
+	game := fs.String("game", "", "game to rate (chunithm, ongeki)")
+	users := addUserFlags(fs)
+	fs.Parse(args)
+
+	if *game == "" || !users.isSet() {
+		fs.Usage()
+		return fmt.Errorf("-game and one of -card, -aime-file or -user are required")
+	}
+
+	gameName, err := parseGameName(*game)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	userID, _, err := users.resolve(db)
+	if err != nil {
+		return err
+	}
+
+	summary, err := ratingSummary(db, gameName, userID)
+	if err != nil {
+		return err
+	}
+	fmt.Print(summary)
+	return nil
+}
+
+func runImportCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	game := fs.String("game", "", "game of the import file (chunithm, ongeki)")
+	user := fs.String("user", "", "Artemis user ID to import into")
+	file := fs.String("file", "", "Tachi batch-manual JSON file or Tachi score export to import")
+	charts := fs.String("charts", "", "Tachi chart documents of the exported scores, when the score export does not include them")
+	dryRun := fs.Bool("dry-run", false, "report what would change without writing anything")
+	fs.Parse(args)
+
+	if *game == "" || *user == "" || *file == "" {
+		fs.Usage()
+		return fmt.Errorf("-game, -user and -file are required")
+	}
+
+	gameName, err := parseGameName(*game)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := importTachiScores(db, gameName, *user, *file, *charts, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Printf("Dry run, nothing was written: %s\n", result)
+	} else {
+		fmt.Printf("Imported into user %s: %s\n", *user, result)
+	}
+	return nil
+}
+
+func runReportCommand(args []string) error {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	game := fs.String("game", "", "game to report on (chunithm, ongeki, wacca)")
+	users := addUserFlags(fs)
+	out := fs.String("out", "", "output file (default exports/<game>_report_<user>.html)")
+	fs.Parse(args)
+
+	if *game == "" || !users.isSet() {
+		fs.Usage()
+		return fmt.Errorf("-game and one of -card, -aime-file or -user are required")
+	}
+
+	gameName, err := parseGameName(*game)
+	if err != nil {
+		return err
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	userID, _, err := users.resolve(db)
+	if err != nil {
+		return err
+	}
+
+	userName, err := queryUserName(db, gameName, userID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch user name: %w", err)
+	}
+
+	path, err := writeHTMLReport(db, gameName, userID, userName, *out)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Report saved to %s\n", path)
+	return nil
+}
+
+func runDiffCommand(args []string) error {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	format := fs.String("format", "text", "output format (text, json)")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: artemis2tachi diff [-format text|json] <old.json> <new.json>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return fmt.Errorf("diff needs exactly two export files")
+	}
+
+	diff, err := diffExportFiles(fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		fmt.Print(diff)
+	case "json":
+		out, err := json.MarshalIndent(diff, "", " ")
+		if err != nil {
+			return fmt.Errorf("failed to marshal diff: %w", err)
+		}
+		fmt.Println(string(out))
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+	return nil
+}
+
+func runHistoryCommand(args []string) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	game := fs.String("game", "", "only list exports of this game")
+	user := fs.String("user", "", "only list exports of this Artemis user ID")
+	limit := fs.Int("limit", 20, "number of exports to list, 0 for all")
+	open := fs.Int64("open", 0, "open the export with this history ID")
+	regenerate := fs.Int64("regenerate", 0, "generate the export with this history ID again")
+	fs.Parse(args)
+
+	switch {
+	case *open != 0 && *regenerate != 0:
+		return fmt.Errorf("-open and -regenerate cannot be used together")
+
+	case *open != 0:
+		entry, err := getExportHistory(*open)
+		if err != nil {
+			return err
+		}
+		fmt.Println(entry)
+		return openExportFile(entry)
+
+	case *regenerate != 0:
+		entry, err := getExportHistory(*regenerate)
+		if err != nil {
+			return err
+		}
+		db, err := openDB()
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		result, err := regenerateExport(db, entry)
+		if err != nil {
+			return err
+		}
+		fmt.Println(result)
+		return nil
+	}
+
+	gameName := ""
+	if *game != "" {
+		var err error
+		if gameName, err = parseGameName(*game); err != nil {
+			return err
+		}
+	}
+
+	entries, err := listExportHistory(gameName, *user, *limit)
+	if err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		fmt.Println("No exports recorded yet.")
+		return nil
+	}
+	checkFiles(entries)
+	for _, entry := range entries {
+		fmt.Println(entry)
+	}
+	return nil
+}
+
+func runBundleCommand(args []string) error {
+	fs := flag.NewFlagSet("bundle", flag.ExitOnError)
+	users := addUserFlags(fs)
+	out := fs.String("out", "", "output file (default exports/bundle_<user>.zip)")
+	fs.Parse(args)
+
+	if !users.isSet() {
+		fs.Usage()
+		return fmt.Errorf("one of -card, -aime-file or -user is required")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	userID, _, err := users.resolve(db)
+	if err != nil {
+		return err
+	}
+
+	path := *out
+	if path == "" {
+		path = fmt.Sprintf("exports/bundle_%s.zip", userID)
+	}
+	manifest, err := writeBundle(db, userID, path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Bundled %d rows from %d tables of user %s into %s\n", manifest.rows(), len(manifest.Tables), userID, path)
+	return nil
+}
+
+func runRestoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	file := fs.String("file", "", "bundle written by the bundle command")
+	user := fs.String("user", "", "existing user ID on the target to restore into (default a new user)")
+	target := fs.String("target-db", "", "MySQL DSN of the Artemis database to restore into (default DB_URL)")
+	dryRun := fs.Bool("dry-run", false, "report what would be restored without writing anything")
+	fs.Parse(args)
+
+	if *file == "" {
+		fs.Usage()
+		return fmt.Errorf("-file is required")
+	}
+
+	var db *sql.DB
+	var err error
+	if *target != "" {
+		db, err = sql.Open("mysql", *target)
+	} else {
+		db, err = openDB()
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	result, err := restoreBundle(db, *file, *user, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Printf("Dry run, nothing was written: %s\n", result)
+	} else {
+		fmt.Printf("Restored %s into %s\n", *file, result)
+	}
+	return nil
+}
+
+func runMergeCommand(args []string) error {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	source := fs.String("source", "", "user ID whose scores and cards are moved")
+	target := fs.String("target", "", "user ID receiving them")
+	yes := fs.Bool("yes", false, "merge without asking after the preview")
+	dryRun := fs.Bool("dry-run", false, "only show the preview")
+	fs.Parse(args)
+
+	if *source == "" || *target == "" {
+		fs.Usage()
+		return fmt.Errorf("-source and -target are required")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	preview, err := mergeUsers(db, *source, *target, false)
+	if err != nil {
+		return err
+	}
+	fmt.Println(preview)
+	if *dryRun {
+		fmt.Println("Dry run, nothing was written.")
+		return nil
+	}
+
+	if !*yes {
+		fmt.Print("Merge these rows? [y/N] ")
+		var answer string
+		fmt.Scanln(&answer)
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Merge cancelled, nothing was written.")
+			return nil
+		}
+	}
+
+	result, err := mergeUsers(db, *source, *target, true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Merged user %s into user %s, %d cards relinked\n", *source, *target, result.Cards)
+	return nil
+}
+
+func runLeaderboardCommand(args []string) error {
+	fs := flag.NewFlagSet("leaderboard", flag.ExitOnError)
+	game := fs.String("game", "", "game of the chart (chunithm, ongeki)")
+	chart := fs.String("chart", "", "music ID of the chart to rank, leave out for the improvements only")
+	difficulty := fs.String("difficulty", "MASTER", "difficulty of the chart")
+	top := fs.Int("top", leaderboardImprovementLimit, "number of improvements this week to list, 0 to leave them out")
+	format := fs.String("format", "", "also save the leaderboard as json or csv")
+	fs.Parse(args)
+
+	if *game == "" {
+		fs.Usage()
+		return fmt.Errorf("-game is required")
+	}
+
+	gameName, err := parseGameName(*game)
+	if err != nil {
+		return err
+	}
+	if *format != "" && *format != "json" && *format != "csv" {
+		return fmt.Errorf("unknown leaderboard format %q (expected json or csv)", *format)
+	}
+	if *format != "" && *chart == "" {
+		return fmt.Errorf("-format needs a -chart to save")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var board *leaderboard
+	if *chart != "" {
+		if board, err = fetchLeaderboard(db, gameName, *chart, *difficulty); err != nil {
+			return err
+		}
+		fmt.Print(board)
+	}
+
+	var improvements []leaderboardImprovement
+	if *top > 0 {
+		if improvements, err = fetchWeeklyImprovements(db, gameName, time.Now().Add(-leaderboardWeek), *top); err != nil {
+			return err
+		}
+		if board != nil {
+			fmt.Println()
+		}
+		fmt.Println("Top improvements this week:")
+		fmt.Print(formatImprovements(improvements))
+	}
+
+	if *format != "" {
+		paths, err := writeLeaderboard(board, improvements, *format)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Saved to %s\n", strings.Join(paths, " and "))
+	}
+	return nil
+}
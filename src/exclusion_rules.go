@@ -0,0 +1,80 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// exclusionRulesFile is picked up from the working directory when no rules
+// file is given explicitly
+const exclusionRulesFile = "exclusion_rules.json"
+
+//go:embed default_exclusion_rules.json
+var defaultExclusionRules []byte
+
+// gameExclusions lists music IDs that only exist on Omnimix or custom chart
+// setups and must never be sent to Tachi
+type gameExclusions struct {
+	Description string     `json:"description,omitempty"`
+	Ranges      [][2]int64 `json:"ranges"`
+	IDs         []int64    `json:"ids"`
+}
+
+// exclusionRules maps a game key ("chunithm", "ongeki", "maimai") to its exclusions
+type exclusionRules map[string]gameExclusions
+
+// loadExclusionRules reads the rules from path. An empty path falls back to
+// exclusion_rules.json in the working directory and then to the built-in defaults.
+func loadExclusionRules(path string) (exclusionRules, error) {
+	data := defaultExclusionRules
+	if path != "" {
+		file, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read exclusion rules: %w", err)
+		}
+		data = file
+	} else if file, err := os.ReadFile(exclusionRulesFile); err == nil {
+		data = file
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read exclusion rules: %w", err)
+	}
+
+	var rules exclusionRules
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse exclusion rules: %w", err)
+	}
+
+	for game, exclusions := range rules {
+		for _, r := range exclusions.Ranges {
+			if r[0] > r[1] {
+				return nil, fmt.Errorf("invalid %s exclusion range %d-%d", game, r[0], r[1])
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+// excludes reports whether musicID is a custom chart for the given game key
+func (r exclusionRules) excludes(game string, musicID int64) bool {
+	exclusions, ok := r[game]
+	if !ok {
+		return false
+	}
+
+	for _, id := range exclusions.IDs {
+		if id == musicID {
+			return true
+		}
+	}
+	for _, idRange := range exclusions.Ranges {
+		if musicID >= idRange[0] && musicID <= idRange[1] {
+			return true
+		}
+	}
+	return false
+}
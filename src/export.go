@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// exportOptions controls which plays the exporters keep
+type exportOptions struct {
+	Filter exportFilter
+	Rules  exclusionRules
+	// RulesPath is the rules file given explicitly, empty for the default lookup
+	RulesPath string
+	// WorldsEnd is the archive format ("json" or "csv") for WORLD'S END plays,
+	// empty drops them like before
+	WorldsEnd string
+	// Version is a Tachi version name such as "sunplus". Only plays from that
+	// release are exported and the batch-manual meta is scoped to it.
+	Version string
+	// BestOnly collapses the export to each chart's best score and lamp
+	BestOnly bool
+	// Chunks splits the export into several files when set
+	Chunks chunkLimits
+	// SkipSent leaves out scores an earlier export already contained, using
+	// the hashes kept in SentState (default exports/sent_scores.json)
+	SkipSent  bool
+	SentState string
+	sent      *sentScores
+	// AnomalyPolicy says what happens to corrupt plays, excluded by default
+	AnomalyPolicy anomalyPolicy
+	anomalies     *anomalyReport
+	// ChartNotes enables the note count check, NoteMismatch says whether
+	// mismatching plays are annotated (the default) or dropped.
+	// ChartNotesPath names the files the counts were loaded from.
+	ChartNotes     chartNoteCounts
+	ChartNotesPath string
+	NoteMismatch   noteMismatchPolicy
+	// Format is the file format, Tachi batch-manual JSON unless set
+	Format exportFormat
+	// Card is the access code the user was resolved from, empty when the
+	// export was requested by user ID. It is only recorded in the log.
+	Card string
+}
+
+// exportStats counts what happened to the scanned playlog rows
+type exportStats struct {
+	Exported       int
+	Filtered       int
+	CustomExcluded int
+	WorldsEnd      int
+	Collapsed      int
+	AlreadySent    int
+	Anomalies      int
+	NoteMismatches int
+}
+
+func (s exportStats) String() string {
+	summary := fmt.Sprintf("%d scores exported, %d filtered out, %d custom charts excluded", s.Exported, s.Filtered, s.CustomExcluded)
+	if s.Collapsed > 0 {
+		summary += fmt.Sprintf(", %d non-best plays collapsed", s.Collapsed)
+	}
+	if s.Anomalies > 0 {
+		summary += fmt.Sprintf(", %d anomalous plays flagged", s.Anomalies)
+	}
+	if s.NoteMismatches > 0 {
+		summary += fmt.Sprintf(", %d note count mismatches", s.NoteMismatches)
+	}
+	if s.AlreadySent > 0 {
+		summary += fmt.Sprintf(", %d already sent skipped", s.AlreadySent)
+	}
+	if s.WorldsEnd > 0 {
+		summary += fmt.Sprintf(", %d WORLD'S END plays archived", s.WorldsEnd)
+	}
+	return summary
+}
+
+// exportOutcome is what a finished export wrote
+type exportOutcome struct {
+	Result string
+	Path   string
+	Stats  exportStats
+}
+
+// exportForGame runs the exporter for the given game and returns a short
+// description of where the result was written.
+func exportForGame(db *sql.DB, game string, userID string, opts exportOptions) (string, error) {
+	outcome, err := runExport(db, game, userID, opts)
+	return outcome.Result, err
+}
+
+// runExport writes the export along with its anomaly report, sent state and
+// history entry
+func runExport(db *sql.DB, game string, userID string, opts exportOptions) (exportOutcome, error) {
+	card := opts.Card
+	if card == "" {
+		card = "none, by user ID"
+	}
+	log.Printf("Exporting %s scores for user %s (card: %s, filters: %s)", game, userID, card, opts.Filter)
+
+	if opts.Version != "" && game != "Chunithm" {
+		return exportOutcome{}, fmt.Errorf("version-scoped exports are only supported for Chunithm")
+	}
+	// Aliases are resolved so the file names the version as Tachi does
+	if opts.Version != "" {
+		release, err := chuniReleaseByTachiID(opts.Version)
+		if err != nil {
+			return exportOutcome{}, err
+		}
+		opts.Version = release.TachiID
+	}
+
+	policy, err := parseAnomalyPolicy(string(opts.AnomalyPolicy))
+	if err != nil {
+		return exportOutcome{}, err
+	}
+	opts.AnomalyPolicy = policy
+	if opts.NoteMismatch, err = parseNoteMismatchPolicy(string(opts.NoteMismatch)); err != nil {
+		return exportOutcome{}, err
+	}
+	if opts.Format, err = parseExportFormat(string(opts.Format)); err != nil {
+		return exportOutcome{}, err
+	}
+	if opts.Format != exportFormatTachi && opts.Chunks.enabled() {
+		return exportOutcome{}, fmt.Errorf("only Tachi exports can be split into parts")
+	}
+	// A local CSV or NDJSON file is not an upload, so it must not mark scores as sent
+	if opts.Format != exportFormatTachi && opts.SkipSent {
+		return exportOutcome{}, fmt.Errorf("skipping sent scores only works for Tachi exports")
+	}
+
+	if opts.SkipSent {
+		sent, err := loadSentScores(opts.SentState, strings.ToLower(game), userID)
+		if err != nil {
+			return exportOutcome{}, err
+		}
+		opts.sent = sent
+	}
+
+	// Chunithm and Ongeki plays are validated, the report lists what was flagged
+	if game == "Chunithm" || game == "Ongeki" {
+		opts.anomalies = &anomalyReport{Game: strings.ToLower(game), Policy: opts.AnomalyPolicy, Anomalies: []anomaly{}}
+	}
+
+	outcome, err := exportGame(db, game, userID, opts)
+	if err != nil {
+		return exportOutcome{}, err
+	}
+
+	if opts.anomalies != nil {
+		if len(opts.anomalies.Anomalies) > 0 {
+			path, err := writeAnomalyReport(opts.anomalies)
+			if err != nil {
+				return exportOutcome{}, err
+			}
+			outcome.Result += fmt.Sprintf("\n%s, listed in %s", opts.anomalies.summary(), path)
+		} else {
+			// A report from an earlier export would no longer be accurate
+			os.Remove(fmt.Sprintf("exports/%s_anomalies.json", opts.anomalies.Game))
+		}
+	}
+
+	// Only remember the scores once the export holding them was written
+	if opts.sent != nil {
+		if err := opts.sent.save(); err != nil {
+			return exportOutcome{}, err
+		}
+	}
+
+	// A missing history must never fail the export itself
+	if err := recordExportHistory(game, userID, opts, outcome); err != nil {
+		log.Printf("Could not record export history: %v", err)
+	}
+	return outcome, nil
+}
+
+// exportGame writes the export of one game, returning the result message and
+// the file to upload, or the manifest of a split export
+func exportGame(db *sql.DB, game string, userID string, opts exportOptions) (exportOutcome, error) {
+	switch game {
+	case "Chunithm":
+		var worldsEnd []worldsEndScore
+		var stats exportStats
+		var result, path string
+		switch {
+		case opts.Format != exportFormatTachi:
+			chuniTachiExport, fetchStats, err := fetchChuniTachiExport(db, userID, opts)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error fetching ChuniTachi export: %w", err)
+			}
+			path, err = writeScoreRecords("chuni_scores", "chunithm", chuniScoreRecords(chuniTachiExport.Scores), opts.Format)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error exporting Chunithm scores: %w", err)
+			}
+			worldsEnd, stats = chuniTachiExport.WorldsEnd, fetchStats
+			result = fmt.Sprintf("Exported to %s (%s)", path, stats)
+		case opts.Chunks.enabled():
+			// Splitting needs every score in play order, so this path is not streamed
+			chuniTachiExport, fetchStats, err := fetchChuniTachiExport(db, userID, opts)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error fetching ChuniTachi export: %w", err)
+			}
+			manifestPath, manifest, err := writeChunkedExport("chuni_tachi_export", "chunithm", chuniTachiExport.Scores, chuniScoreRecords(chuniTachiExport.Scores), func(scores []BatchManualScoreChuni) any {
+				part := *chuniTachiExport
+				part.Scores = scores
+				return &part
+			}, opts.Chunks)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error exporting Chuni to Tachi: %w", err)
+			}
+			worldsEnd, stats, path = chuniTachiExport.WorldsEnd, fetchStats, manifestPath
+			result = chunkedResult(manifestPath, manifest, stats)
+		default:
+			var err error
+			worldsEnd, stats, err = exportChuniToTachi(db, userID, opts)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error exporting Chuni to Tachi: %w", err)
+			}
+			result = fmt.Sprintf("Exported to Tachi and saved to chuni_tachi_export.json (%s)", stats)
+			path = "exports/chuni_tachi_export.json"
+		}
+		log.Printf("Chunithm export for user %s: %s", userID, stats)
+
+		if opts.WorldsEnd != "" {
+			archivePath, err := exportChuniWorldsEnd(db, worldsEnd, opts.WorldsEnd)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error exporting WORLD'S END archive: %w", err)
+			}
+			result += fmt.Sprintf("\nWORLD'S END plays saved to %s", archivePath)
+		}
+		return exportOutcome{Result: result, Path: path, Stats: stats}, nil
+
+	case "Ongeki":
+		gekiTachiExport, stats, err := fetchOngekiExport(db, userID, opts)
+		if err != nil {
+			return exportOutcome{}, fmt.Errorf("error fetching Ongeki export: %w", err)
+		}
+		log.Printf("Ongeki export for user %s: %s", userID, stats)
+		if opts.Format != exportFormatTachi {
+			path, err := writeScoreRecords("ongeki_scores", "ongeki", gekiScoreRecords(gekiTachiExport.Scores), opts.Format)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error exporting Ongeki scores: %w", err)
+			}
+			return exportOutcome{Result: fmt.Sprintf("Exported to %s (%s)", path, stats), Path: path, Stats: stats}, nil
+		}
+		if opts.Chunks.enabled() {
+			manifestPath, manifest, err := writeChunkedExport("ongeki_tachi_export", "ongeki", gekiTachiExport.Scores, gekiScoreRecords(gekiTachiExport.Scores), func(scores []BatchManualScoreGeki) any {
+				part := *gekiTachiExport
+				part.Scores = scores
+				return &part
+			}, opts.Chunks)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error exporting Ongeki to Tachi: %w", err)
+			}
+			return exportOutcome{Result: chunkedResult(manifestPath, manifest, stats), Path: manifestPath, Stats: stats}, nil
+		}
+		if err := exportOngekiToTachi(gekiTachiExport); err != nil {
+			return exportOutcome{}, fmt.Errorf("error exporting Ongeki to Tachi: %w", err)
+		}
+		return exportOutcome{
+			Result: fmt.Sprintf("Exported to Tachi and saved to ongeki_tachi_export.json (%s)", stats),
+			Path:   "exports/ongeki_tachi_export.json",
+			Stats:  stats,
+		}, nil
+
+	case "WACCA":
+		waccaTachiExport, stats, err := fetchWaccaExport(db, userID, opts)
+		if err != nil {
+			return exportOutcome{}, fmt.Errorf("error fetching WACCA export: %w", err)
+		}
+		log.Printf("WACCA export for user %s: %s", userID, stats)
+		if opts.Format != exportFormatTachi {
+			path, err := writeScoreRecords("wacca_scores", "wacca", waccaScoreRecords(waccaTachiExport.Scores), opts.Format)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error exporting WACCA scores: %w", err)
+			}
+			return exportOutcome{Result: fmt.Sprintf("Exported to %s (%s)", path, stats), Path: path, Stats: stats}, nil
+		}
+		if opts.Chunks.enabled() {
+			manifestPath, manifest, err := writeChunkedExport("wacca_tachi_export", "wacca", waccaTachiExport.Scores, waccaScoreRecords(waccaTachiExport.Scores), func(scores []BatchManualScoreWacca) any {
+				part := *waccaTachiExport
+				part.Scores = scores
+				return &part
+			}, opts.Chunks)
+			if err != nil {
+				return exportOutcome{}, fmt.Errorf("error exporting WACCA to Tachi: %w", err)
+			}
+			return exportOutcome{Result: chunkedResult(manifestPath, manifest, stats), Path: manifestPath, Stats: stats}, nil
+		}
+		if err := exportWaccaToTachi(waccaTachiExport); err != nil {
+			return exportOutcome{}, fmt.Errorf("error exporting WACCA to Tachi: %w", err)
+		}
+		return exportOutcome{
+			Result: fmt.Sprintf("Exported to Tachi and saved to wacca_tachi_export.json (%s)", stats),
+			Path:   "exports/wacca_tachi_export.json",
+			Stats:  stats,
+		}, nil
+
+	case "MaiMai":
+		return exportOutcome{}, fmt.Errorf("MaiMai export not implemented yet")
+
+	default:
+		return exportOutcome{}, fmt.Errorf("unsupported game: %s", game)
+	}
+}
+
+func chunkedResult(manifestPath string, manifest chunkManifest, stats exportStats) string {
+	return fmt.Sprintf("Exported to Tachi and split into %d parts listed in %s (%s)", len(manifest.Parts), manifestPath, stats)
+}
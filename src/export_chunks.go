@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chunkLimits caps each part of a split export, zero means no limit
+type chunkLimits struct {
+	MaxScores int
+	MaxBytes  int
+}
+
+func (l chunkLimits) enabled() bool {
+	return l.MaxScores > 0 || l.MaxBytes > 0
+}
+
+type chunkPart struct {
+	File   string     `json:"file"`
+	Scores int        `json:"scores"`
+	Bytes  int        `json:"bytes"`
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+}
+
+// chunkManifest lists the parts of a split export in upload order
+type chunkManifest struct {
+	Game        string      `json:"game"`
+	TotalScores int         `json:"totalScores"`
+	MaxScores   int         `json:"maxScores,omitempty"`
+	MaxBytes    int         `json:"maxBytes,omitempty"`
+	Parts       []chunkPart `json:"parts"`
+}
+
+// parseByteSize reads sizes such as 500000, 512KB or 5MB
+func parseByteSize(size string) (int, error) {
+	size = strings.ToUpper(strings.TrimSpace(size))
+	if size == "" {
+		return 0, nil
+	}
+
+	multiplier := 1
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int
+	}{{"MB", 1 << 20}, {"KB", 1 << 10}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1}} {
+		if strings.HasSuffix(size, unit.suffix) {
+			size = strings.TrimSpace(strings.TrimSuffix(size, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+
+	value, err := strconv.Atoi(size)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid size %q (expected e.g. 500000, 512KB or 5MB)", size)
+	}
+	return value * multiplier, nil
+}
+
+// writeChunkedExport splits scores into several batch-manual files under
+// exports/, each one a complete file built by withScores so they all share
+// the same meta. Scores are ordered by play time and parts only break between
+// different timestamps, unless a single timestamp holds more than a part can.
+// records must be the scoreRecords of scores, index for index.
+func writeChunkedExport[T any](name string, game string, scores []T, records []scoreRecord, withScores func([]T) any, limits chunkLimits) (string, chunkManifest, error) {
+	manifest := chunkManifest{Game: game, TotalScores: len(scores), MaxScores: limits.MaxScores, MaxBytes: limits.MaxBytes, Parts: []chunkPart{}}
+
+	order := make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+	// Undated plays go last
+	sort.SliceStable(order, func(a, b int) bool {
+		ta, tb := records[order[a]].Time, records[order[b]].Time
+		if ta == nil || tb == nil {
+			return ta != nil && tb == nil
+		}
+		return ta.Before(*tb)
+	})
+
+	// Sizes are worked out the same way MarshalIndent lays out the file: an
+	// empty export ends in "null", every score adds its own lines and a
+	// 4 byte separator, and the closing bracket adds 3 more
+	empty, err := json.MarshalIndent(withScores(nil), "", " ")
+	if err != nil {
+		return "", manifest, fmt.Errorf("failed to marshal export data: %w", err)
+	}
+	baseSize := len(empty) - len("null") + len("\n ]")
+
+	sizes := make([]int, len(scores))
+	for i, score := range scores {
+		data, err := json.MarshalIndent(score, "  ", " ")
+		if err != nil {
+			return "", manifest, fmt.Errorf("failed to marshal export data: %w", err)
+		}
+		sizes[i] = len(data) + 4
+		if limits.MaxBytes > 0 && baseSize+sizes[i] > limits.MaxBytes {
+			return "", manifest, fmt.Errorf("a part can hold at most %d bytes but a single score needs %d", limits.MaxBytes, baseSize+sizes[i])
+		}
+	}
+
+	fits := func(count int, size int) bool {
+		return (limits.MaxScores <= 0 || count <= limits.MaxScores) && (limits.MaxBytes <= 0 || size <= limits.MaxBytes)
+	}
+
+	var parts [][]int
+	var current []int
+	currentSize := baseSize
+	for start := 0; start < len(order); {
+		// A group is every play sharing the timestamp of order[start]
+		end := start + 1
+		for end < len(order) && sameTime(records[order[start]].Time, records[order[end]].Time) {
+			end++
+		}
+		groupSize := 0
+		for _, i := range order[start:end] {
+			groupSize += sizes[i]
+		}
+
+		if len(current) > 0 && !fits(len(current)+end-start, currentSize+groupSize) {
+			parts = append(parts, current)
+			current, currentSize = nil, baseSize
+		}
+		for _, i := range order[start:end] {
+			if len(current) > 0 && !fits(len(current)+1, currentSize+sizes[i]) {
+				parts = append(parts, current)
+				current, currentSize = nil, baseSize
+			}
+			current = append(current, i)
+			currentSize += sizes[i]
+		}
+		start = end
+	}
+	if len(current) > 0 || len(parts) == 0 {
+		parts = append(parts, current)
+	}
+
+	if err := os.MkdirAll("exports", 0755); err != nil {
+		return "", manifest, fmt.Errorf("failed to create exports directory: %w", err)
+	}
+	// Parts left over from an earlier, larger split would look like part of this one
+	stale, _ := filepath.Glob(filepath.Join("exports", name+".part*.json"))
+	for _, path := range stale {
+		os.Remove(path)
+	}
+
+	for n, part := range parts {
+		partScores := make([]T, 0, len(part))
+		for _, i := range part {
+			partScores = append(partScores, scores[i])
+		}
+
+		file, err := json.MarshalIndent(withScores(partScores), "", " ")
+		if err != nil {
+			return "", manifest, fmt.Errorf("failed to marshal export data: %w", err)
+		}
+		fileName := fmt.Sprintf("%s.part%03d.json", name, n+1)
+		if err := os.WriteFile(filepath.Join("exports", fileName), file, 0644); err != nil {
+			return "", manifest, fmt.Errorf("failed to write export file: %w", err)
+		}
+
+		entry := chunkPart{File: fileName, Scores: len(part), Bytes: len(file)}
+		if len(part) > 0 {
+			entry.From = records[part[0]].Time
+			entry.To = records[part[len(part)-1]].Time
+		}
+		manifest.Parts = append(manifest.Parts, entry)
+	}
+
+	manifestPath := filepath.Join("exports", name+"_manifest.json")
+	file, err := json.MarshalIndent(manifest, "", " ")
+	if err != nil {
+		return "", manifest, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(manifestPath, file, 0644); err != nil {
+		return "", manifest, fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return manifestPath, manifest, nil
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"500000", 500000, false},
+		{"512KB", 512 << 10, false},
+		{"512k", 512 << 10, false},
+		{" 5 MB ", 5 << 20, false},
+		{"5m", 5 << 20, false},
+		{"100B", 100, false},
+		{"-1", 0, true},
+		{"lots", 0, true},
+		{"1.5MB", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseByteSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+// chunkTestScores returns n plays, every two sharing a timestamp
+func chunkTestScores(n int) []BatchManualScoreChuni {
+	var scores []BatchManualScoreChuni
+	for i := 0; i < n; i++ {
+		timeAchieved := int64(1700000000 + i/2*60)
+		scores = append(scores, BatchManualScoreChuni{
+			Identifier:   fmt.Sprint(100 + i),
+			MatchType:    "inGameID",
+			Score:        900000 + i,
+			Lamp:         Clear,
+			Difficulty:   "MASTER",
+			TimeAchieved: &timeAchieved,
+		})
+	}
+	return scores
+}
+
+func TestWriteChunkedExport(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+
+	tests := []struct {
+		name      string
+		scores    int
+		limits    chunkLimits
+		wantParts int
+	}{
+		{"no scores", 0, chunkLimits{MaxScores: 10}, 1},
+		{"fits one part", 5, chunkLimits{MaxScores: 10}, 1},
+		{"max scores", 10, chunkLimits{MaxScores: 4}, 3},
+		// Three plays fit but the third would split a timestamp
+		{"max scores keeps timestamps together", 6, chunkLimits{MaxScores: 3}, 3},
+		{"max bytes", 12, chunkLimits{MaxBytes: 1200}, 2},
+		{"both limits", 20, chunkLimits{MaxScores: 6, MaxBytes: 1500}, 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.Chdir(t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			scores := chunkTestScores(tt.scores)
+			withScores := func(scores []BatchManualScoreChuni) any {
+				export := BatchManualImportChuni{Scores: scores}
+				export.Meta.Game = "chunithm"
+				return export
+			}
+
+			path, manifest, err := writeChunkedExport("chuni_test", "chunithm", scores, chuniScoreRecords(scores), withScores, tt.limits)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if path != filepath.Join("exports", "chuni_test_manifest.json") {
+				t.Errorf("manifest written to %s", path)
+			}
+			if len(manifest.Parts) != tt.wantParts {
+				t.Errorf("got %d parts, want %d", len(manifest.Parts), tt.wantParts)
+			}
+			if manifest.TotalScores != tt.scores || manifest.MaxScores != tt.limits.MaxScores || manifest.MaxBytes != tt.limits.MaxBytes {
+				t.Errorf("manifest header = %+v", manifest)
+			}
+
+			var written chunkManifest
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(data, &written); err != nil {
+				t.Fatal(err)
+			}
+			if len(written.Parts) != len(manifest.Parts) {
+				t.Fatalf("manifest file lists %d parts, returned manifest %d", len(written.Parts), len(manifest.Parts))
+			}
+
+			next := 0
+			for n, part := range written.Parts {
+				file, err := os.ReadFile(filepath.Join("exports", part.File))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if part.Bytes != len(file) {
+					t.Errorf("part %d: manifest says %d bytes, file has %d", n+1, part.Bytes, len(file))
+				}
+				if tt.limits.MaxBytes > 0 && len(file) > tt.limits.MaxBytes {
+					t.Errorf("part %d: %d bytes, over the limit of %d", n+1, len(file), tt.limits.MaxBytes)
+				}
+				if tt.limits.MaxScores > 0 && part.Scores > tt.limits.MaxScores {
+					t.Errorf("part %d: %d scores, over the limit of %d", n+1, part.Scores, tt.limits.MaxScores)
+				}
+
+				var export BatchManualImportChuni
+				if err := json.Unmarshal(file, &export); err != nil {
+					t.Fatalf("part %d: %v", n+1, err)
+				}
+				if export.Meta.Game != "chunithm" {
+					t.Errorf("part %d: meta game %q", n+1, export.Meta.Game)
+				}
+				if len(export.Scores) != part.Scores {
+					t.Errorf("part %d: manifest says %d scores, file has %d", n+1, part.Scores, len(export.Scores))
+				}
+				// Parts hold the plays in order without gaps
+				for _, score := range export.Scores {
+					if next >= len(scores) || score.Identifier != scores[next].Identifier {
+						t.Fatalf("part %d: unexpected score %s", n+1, score.Identifier)
+					}
+					next++
+				}
+				if len(export.Scores) > 0 {
+					if part.From == nil || part.From.Unix() != *export.Scores[0].TimeAchieved {
+						t.Errorf("part %d: from = %v", n+1, part.From)
+					}
+					if part.To == nil || part.To.Unix() != *export.Scores[len(export.Scores)-1].TimeAchieved {
+						t.Errorf("part %d: to = %v", n+1, part.To)
+					}
+				}
+				// A timestamp never continues in the next part when it would have fit
+				if n > 0 && len(export.Scores) > 0 && part.From.Equal(*written.Parts[n-1].To) {
+					t.Errorf("part %d: timestamp %v split across parts", n+1, part.From)
+				}
+			}
+			if next != len(scores) {
+				t.Errorf("parts hold %d scores, want %d", next, len(scores))
+			}
+		})
+	}
+}
+
+func TestWriteChunkedExportScoreTooLarge(t *testing.T) {
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(dir) })
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	scores := chunkTestScores(1)
+	withScores := func(scores []BatchManualScoreChuni) any { return BatchManualImportChuni{Scores: scores} }
+	if _, _, err := writeChunkedExport("chuni_test", "chunithm", scores, chuniScoreRecords(scores), withScores, chunkLimits{MaxBytes: 100}); err == nil {
+		t.Error("expected an error for a score larger than a part")
+	}
+}
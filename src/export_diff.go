@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+type diffPlay struct {
+	Identifier string     `json:"identifier"`
+	Difficulty string     `json:"difficulty"`
+	Score      int        `json:"score"`
+	Lamp       string     `json:"lamp"`
+	Time       *time.Time `json:"time,omitempty"`
+}
+
+type diffPB struct {
+	Identifier string `json:"identifier"`
+	Difficulty string `json:"difficulty"`
+	// OldScore is nil for charts that were not played before
+	OldScore *int `json:"oldScore"`
+	NewScore int  `json:"newScore"`
+}
+
+type diffLamp struct {
+	Identifier string `json:"identifier"`
+	Difficulty string `json:"difficulty"`
+	OldLamp    string `json:"oldLamp"`
+	NewLamp    string `json:"newLamp"`
+}
+
+type diffChart struct {
+	Identifier string `json:"identifier"`
+	Difficulty string `json:"difficulty"`
+	BestScore  int    `json:"bestScore"`
+	Plays      int    `json:"plays"`
+}
+
+// exportDiff is what changed between two batch-manual files of one player
+type exportDiff struct {
+	Game         string      `json:"game"`
+	NewPlays     []diffPlay  `json:"newPlays"`
+	NewPBs       []diffPB    `json:"newPBs"`
+	LampUpgrades []diffLamp  `json:"lampUpgrades"`
+	Disappeared  []diffChart `json:"disappearedCharts"`
+}
+
+// loadBatchManualRecords reads a batch-manual file written by any exporter
+func loadBatchManualRecords(path string) (string, []scoreRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var header struct {
+		Meta struct {
+			Game string `json:"game"`
+		} `json:"meta"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return "", nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	var records []scoreRecord
+	switch header.Meta.Game {
+	case "chunithm":
+		var tachiExport BatchManualImportChuni
+		if err := json.Unmarshal(data, &tachiExport); err != nil {
+			return "", nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		records = chuniScoreRecords(tachiExport.Scores)
+	case "ongeki":
+		var tachiExport BatchManualImportGeki
+		if err := json.Unmarshal(data, &tachiExport); err != nil {
+			return "", nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		records = gekiScoreRecords(tachiExport.Scores)
+	case "wacca":
+		var tachiExport BatchManualImportWacca
+		if err := json.Unmarshal(data, &tachiExport); err != nil {
+			return "", nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+		records = waccaScoreRecords(tachiExport.Scores)
+	default:
+		return "", nil, fmt.Errorf("%s is not a supported batch-manual file (game %q)", path, header.Meta.Game)
+	}
+
+	sortRecordsByTime(records)
+	return header.Meta.Game, records, nil
+}
+
+// playIdentity identifies a play across exports
+func playIdentity(r scoreRecord) string {
+	var timestamp int64
+	if r.Time != nil {
+		timestamp = r.Time.Unix()
+	}
+	return fmt.Sprintf("%s|%s|%d|%s|%d", r.Identifier, r.Difficulty, r.Score, r.Lamp, timestamp)
+}
+
+// diffExports compares two sets of records of the same game
+func diffExports(game string, oldRecords, newRecords []scoreRecord) exportDiff {
+	diff := exportDiff{
+		Game:         game,
+		NewPlays:     []diffPlay{},
+		NewPBs:       []diffPB{},
+		LampUpgrades: []diffLamp{},
+		Disappeared:  []diffChart{},
+	}
+
+	// Plays are compared as a multiset, the same play can legitimately repeat
+	seen := make(map[string]int)
+	for _, record := range oldRecords {
+		seen[playIdentity(record)]++
+	}
+	for _, record := range newRecords {
+		identity := playIdentity(record)
+		if seen[identity] > 0 {
+			seen[identity]--
+			continue
+		}
+		diff.NewPlays = append(diff.NewPlays, diffPlay{
+			Identifier: record.Identifier,
+			Difficulty: record.Difficulty,
+			Score:      record.Score,
+			Lamp:       record.Lamp,
+			Time:       record.Time,
+		})
+	}
+
+	oldBests := make(map[chartKey]chartBest)
+	for _, best := range personalBests(oldRecords) {
+		oldBests[best.Chart] = best
+	}
+	newBests := make(map[chartKey]bool)
+	for _, best := range personalBests(newRecords) {
+		newBests[best.Chart] = true
+
+		old, played := oldBests[best.Chart]
+		if !played || best.Score > old.Score {
+			pb := diffPB{Identifier: best.Chart.Identifier, Difficulty: best.Chart.Difficulty, NewScore: best.Score}
+			if played {
+				oldScore := old.Score
+				pb.OldScore = &oldScore
+			}
+			diff.NewPBs = append(diff.NewPBs, pb)
+		}
+		if played && best.LampRank > old.LampRank {
+			diff.LampUpgrades = append(diff.LampUpgrades, diffLamp{
+				Identifier: best.Chart.Identifier,
+				Difficulty: best.Chart.Difficulty,
+				OldLamp:    old.Lamp,
+				NewLamp:    best.Lamp,
+			})
+		}
+	}
+
+	for _, old := range personalBests(oldRecords) {
+		if !newBests[old.Chart] {
+			diff.Disappeared = append(diff.Disappeared, diffChart{
+				Identifier: old.Chart.Identifier,
+				Difficulty: old.Chart.Difficulty,
+				BestScore:  old.Score,
+				Plays:      old.Plays,
+			})
+		}
+	}
+
+	return diff
+}
+
+// diffExportFiles loads and compares two batch-manual files
+func diffExportFiles(oldPath, newPath string) (exportDiff, error) {
+	oldGame, oldRecords, err := loadBatchManualRecords(oldPath)
+	if err != nil {
+		return exportDiff{}, err
+	}
+	newGame, newRecords, err := loadBatchManualRecords(newPath)
+	if err != nil {
+		return exportDiff{}, err
+	}
+	if oldGame != newGame {
+		return exportDiff{}, fmt.Errorf("cannot compare a %s export with a %s export", oldGame, newGame)
+	}
+
+	return diffExports(oldGame, oldRecords, newRecords), nil
+}
+
+func (d exportDiff) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d new plays, %d new PBs, %d lamp upgrades, %d charts disappeared\n",
+		d.Game, len(d.NewPlays), len(d.NewPBs), len(d.LampUpgrades), len(d.Disappeared))
+
+	if len(d.Disappeared) > 0 {
+		b.WriteString("\nDisappeared charts (present in the old export only, check the database):\n")
+		for _, chart := range d.Disappeared {
+			fmt.Fprintf(&b, "  %6s %-9s best %d over %d plays\n", chart.Identifier, chart.Difficulty, chart.BestScore, chart.Plays)
+		}
+	}
+
+	if len(d.NewPBs) > 0 {
+		b.WriteString("\nNew PBs:\n")
+		for _, pb := range d.NewPBs {
+			if pb.OldScore == nil {
+				fmt.Fprintf(&b, "  %6s %-9s %d (first play)\n", pb.Identifier, pb.Difficulty, pb.NewScore)
+			} else {
+				fmt.Fprintf(&b, "  %6s %-9s %d -> %d (+%d)\n", pb.Identifier, pb.Difficulty, *pb.OldScore, pb.NewScore, pb.NewScore-*pb.OldScore)
+			}
+		}
+	}
+
+	if len(d.LampUpgrades) > 0 {
+		b.WriteString("\nLamp upgrades:\n")
+		for _, lamp := range d.LampUpgrades {
+			fmt.Fprintf(&b, "  %6s %-9s %s -> %s\n", lamp.Identifier, lamp.Difficulty, lamp.OldLamp, lamp.NewLamp)
+		}
+	}
+
+	if len(d.NewPlays) > 0 {
+		b.WriteString("\nNew plays:\n")
+		for _, play := range d.NewPlays {
+			date := "unknown date"
+			if play.Time != nil {
+				date = play.Time.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(&b, "  %s  %6s %-9s %d %s\n", date, play.Identifier, play.Difficulty, play.Score, play.Lamp)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const filterDateLayout = "2006-01-02"
+
+// exportFilter narrows down which plays end up in an export.
+// The zero value lets every play through.
+type exportFilter struct {
+	From          *time.Time
+	To            *time.Time
+	Difficulties  map[string]bool
+	MinRomVersion string
+	MaxRomVersion string
+	IncludeMusic  map[int64]bool
+	ExcludeMusic  map[int64]bool
+}
+
+// newExportFilter builds a filter from the raw text used by both the CLI flags
+// and the TUI filter form. Empty fields are ignored.
+func newExportFilter(from, to, difficulties, minRom, maxRom, include, exclude string) (exportFilter, error) {
+	var filter exportFilter
+
+	if from = strings.TrimSpace(from); from != "" {
+		t, err := time.Parse(filterDateLayout, from)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from date %q (expected YYYY-MM-DD)", from)
+		}
+		filter.From = &t
+	}
+	if to = strings.TrimSpace(to); to != "" {
+		t, err := time.Parse(filterDateLayout, to)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to date %q (expected YYYY-MM-DD)", to)
+		}
+		// The end date is inclusive, so move to the last second of that day
+		t = t.Add(24*time.Hour - time.Second)
+		filter.To = &t
+	}
+	if filter.From != nil && filter.To != nil && filter.From.After(*filter.To) {
+		return filter, fmt.Errorf("from date is after to date")
+	}
+
+	for _, difficulty := range splitList(difficulties) {
+		if filter.Difficulties == nil {
+			filter.Difficulties = make(map[string]bool)
+		}
+		filter.Difficulties[strings.ToUpper(difficulty)] = true
+	}
+
+	filter.MinRomVersion = strings.TrimSpace(minRom)
+	filter.MaxRomVersion = strings.TrimSpace(maxRom)
+	if filter.MinRomVersion != "" && filter.MaxRomVersion != "" && compareRomVersions(filter.MinRomVersion, filter.MaxRomVersion) > 0 {
+		return filter, fmt.Errorf("minimum rom version is above maximum rom version")
+	}
+
+	var err error
+	if filter.IncludeMusic, err = parseMusicIDs(include); err != nil {
+		return filter, err
+	}
+	if filter.ExcludeMusic, err = parseMusicIDs(exclude); err != nil {
+		return filter, err
+	}
+
+	return filter, nil
+}
+
+// allowsPlay reports whether a single play passes every active filter.
+// playedAt may be nil when the play has no usable date.
+func (f exportFilter) allowsPlay(playedAt *time.Time, difficulty string, musicID int64, romVersion string) bool {
+	if f.From != nil || f.To != nil {
+		if playedAt == nil {
+			return false
+		}
+		if f.From != nil && playedAt.Before(*f.From) {
+			return false
+		}
+		if f.To != nil && playedAt.After(*f.To) {
+			return false
+		}
+	}
+
+	if len(f.Difficulties) > 0 && !f.Difficulties[difficulty] {
+		return false
+	}
+
+	// Games without a rom version on their playlogs pass "" and skip this check
+	if romVersion != "" {
+		if f.MinRomVersion != "" && compareRomVersions(romVersion, f.MinRomVersion) < 0 {
+			return false
+		}
+		if f.MaxRomVersion != "" && compareRomVersions(romVersion, f.MaxRomVersion) > 0 {
+			return false
+		}
+	}
+
+	if len(f.IncludeMusic) > 0 && !f.IncludeMusic[musicID] {
+		return false
+	}
+	if f.ExcludeMusic[musicID] {
+		return false
+	}
+
+	return true
+}
+
+func (f exportFilter) isEmpty() bool {
+	return f.From == nil && f.To == nil && len(f.Difficulties) == 0 &&
+		f.MinRomVersion == "" && f.MaxRomVersion == "" &&
+		len(f.IncludeMusic) == 0 && len(f.ExcludeMusic) == 0
+}
+
+// String describes the active filters, used for the export log
+func (f exportFilter) String() string {
+	if f.isEmpty() {
+		return "none"
+	}
+
+	var parts []string
+	if f.From != nil {
+		parts = append(parts, "from="+f.From.Format(filterDateLayout))
+	}
+	if f.To != nil {
+		parts = append(parts, "to="+f.To.Format(filterDateLayout))
+	}
+	if len(f.Difficulties) > 0 {
+		var difficulties []string
+		for difficulty := range f.Difficulties {
+			difficulties = append(difficulties, difficulty)
+		}
+		sort.Strings(difficulties)
+		parts = append(parts, "difficulty="+strings.Join(difficulties, ","))
+	}
+	if f.MinRomVersion != "" {
+		parts = append(parts, "romMin="+f.MinRomVersion)
+	}
+	if f.MaxRomVersion != "" {
+		parts = append(parts, "romMax="+f.MaxRomVersion)
+	}
+	if len(f.IncludeMusic) > 0 {
+		parts = append(parts, "include="+formatMusicIDs(f.IncludeMusic))
+	}
+	if len(f.ExcludeMusic) > 0 {
+		parts = append(parts, "exclude="+formatMusicIDs(f.ExcludeMusic))
+	}
+	return strings.Join(parts, " ")
+}
+
+// parseExportFilter reads a filter back from its String form, as stored in
+// the export history
+func parseExportFilter(description string) (exportFilter, error) {
+	if description == "none" || description == "" {
+		return exportFilter{}, nil
+	}
+
+	fields := make(map[string]string)
+	lastKey := ""
+	for _, part := range strings.Split(description, " ") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			// Difficulties such as WORLD'S END contain a space
+			if lastKey == "" {
+				return exportFilter{}, fmt.Errorf("invalid filter %q", description)
+			}
+			fields[lastKey] += " " + part
+			continue
+		}
+		fields[key] = value
+		lastKey = key
+	}
+	return newExportFilter(fields["from"], fields["to"], fields["difficulty"], fields["romMin"], fields["romMax"], fields["include"], fields["exclude"])
+}
+
+// compareRomVersions compares dotted rom versions such as "2.15.00" numerically.
+// Missing or non-numeric parts count as zero.
+func compareRomVersions(a, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aNum, bNum int
+		if i < len(aParts) {
+			aNum, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bNum, _ = strconv.Atoi(bParts[i])
+		}
+		if aNum != bNum {
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseMusicIDs(value string) (map[int64]bool, error) {
+	items := splitList(value)
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	ids := make(map[int64]bool, len(items))
+	for _, item := range items {
+		id, err := strconv.ParseInt(item, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid music ID %q", item)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+func formatMusicIDs(ids map[int64]bool) string {
+	var sorted []int64
+	for id := range ids {
+		sorted = append(sorted, id)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var parts []string
+	for _, id := range sorted {
+		parts = append(parts, strconv.FormatInt(id, 10))
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,479 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// The history lives next to the exports it describes. The SQLite driver is
+// pure Go, so cross-compiled builds without cgo record history too.
+const historyDBPath = "exports/history.db"
+
+// Every export is copied below historyDir, since the next export of the same
+// game overwrites the file it was written to. The exclusion rules it was made
+// with are kept next to the copy as historyRulesFile.
+const (
+	historyDir       = "exports/history"
+	historyRulesFile = "exclusion_rules.json"
+)
+
+// historyOptions are the export settings besides filters needed to generate
+// an export again
+type historyOptions struct {
+	WorldsEnd string `json:"worldsEnd,omitempty"`
+	Version   string `json:"version,omitempty"`
+	BestOnly  bool   `json:"bestOnly,omitempty"`
+	SkipSent  bool   `json:"skipSent,omitempty"`
+	MaxScores int    `json:"maxScores,omitempty"`
+	MaxBytes  int    `json:"maxBytes,omitempty"`
+	Anomalies string `json:"anomalies,omitempty"`
+	Format    string `json:"format,omitempty"`
+	// ChartNotes are the chart files of the note count check, empty when
+	// the check was off
+	ChartNotes   string `json:"chartNotes,omitempty"`
+	NoteMismatch string `json:"noteMismatch,omitempty"`
+	// Rules is the rules file given explicitly, RulesChecksum identifies the
+	// rules that were applied
+	Rules         string `json:"rules,omitempty"`
+	RulesChecksum string `json:"rulesChecksum,omitempty"`
+}
+
+type historyEntry struct {
+	ID         int64
+	ExportedAt time.Time
+	Game       string
+	UserID     string
+	Card       string
+	Filters    string
+	Options    historyOptions
+	Scores     int
+	Path       string
+	Checksum   string
+	// Status is the result of fileStatus, filled in by checkFiles
+	Status string
+}
+
+func openHistory() (*sql.DB, error) {
+	if err := os.MkdirAll("exports", 0755); err != nil {
+		return nil, fmt.Errorf("failed to create exports directory: %w", err)
+	}
+
+	history, err := sql.Open("sqlite", historyDBPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open export history: %w", err)
+	}
+	_, err = history.Exec(`
+		CREATE TABLE IF NOT EXISTS export_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			exported_at TEXT NOT NULL,
+			game TEXT NOT NULL,
+			user_id TEXT NOT NULL,
+			card TEXT NOT NULL,
+			filters TEXT NOT NULL,
+			options TEXT NOT NULL,
+			scores INTEGER NOT NULL,
+			path TEXT NOT NULL,
+			checksum TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		history.Close()
+		return nil, fmt.Errorf("failed to create export history table: %w", err)
+	}
+	return history, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// manifestParts lists the part files of a chunked export, which sit next to
+// its manifest. Other exports have no parts.
+func manifestParts(path string) ([]string, error) {
+	if !strings.HasSuffix(path, "_manifest.json") {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest chunkManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest %s: %w", path, err)
+	}
+	var parts []string
+	for _, part := range manifest.Parts {
+		parts = append(parts, filepath.Join(filepath.Dir(path), part.File))
+	}
+	return parts, nil
+}
+
+// exportChecksum hashes an export file, and for a chunked export the
+// checksums of its parts along with the manifest
+func exportChecksum(path string) (string, error) {
+	checksum, err := fileChecksum(path)
+	if err != nil {
+		return "", err
+	}
+	parts, err := manifestParts(path)
+	if err != nil || parts == nil {
+		return checksum, err
+	}
+
+	hash := sha256.New()
+	hash.Write([]byte(checksum))
+	for _, part := range parts {
+		partChecksum, err := fileChecksum(part)
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(partChecksum))
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func copyFile(source string, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// archiveExport copies an export and its parts to a directory of its own
+// below historyDir and returns the path of the copy
+func archiveExport(game string, userID string, path string, exportedAt time.Time) (string, error) {
+	dir := filepath.Join(historyDir, fmt.Sprintf("%s_%s_%s", strings.ToLower(game), userID, exportedAt.Format("20060102-150405.000")))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	parts, err := manifestParts(path)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range append(parts, path) {
+		if err := copyFile(file, filepath.Join(dir, filepath.Base(file))); err != nil {
+			return "", fmt.Errorf("failed to copy %s to the history: %w", file, err)
+		}
+	}
+	return filepath.Join(dir, filepath.Base(path)), nil
+}
+
+func recordExportHistory(game string, userID string, opts exportOptions, outcome exportOutcome) error {
+	exportedAt := time.Now().UTC()
+	path, err := archiveExport(game, userID, outcome.Path, exportedAt)
+	if err != nil {
+		return err
+	}
+	checksum, err := exportChecksum(path)
+	if err != nil {
+		return fmt.Errorf("failed to checksum %s: %w", path, err)
+	}
+
+	rules, err := json.MarshalIndent(opts.Rules, "", " ")
+	if err != nil {
+		return err
+	}
+	rulesPath := filepath.Join(filepath.Dir(path), historyRulesFile)
+	if err := os.WriteFile(rulesPath, rules, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", rulesPath, err)
+	}
+	rulesChecksum := sha256.Sum256(rules)
+
+	var chartNotes, noteMismatch string
+	if opts.ChartNotes != nil {
+		chartNotes, noteMismatch = opts.ChartNotesPath, string(opts.NoteMismatch)
+	}
+	options, err := json.Marshal(historyOptions{
+		WorldsEnd:     opts.WorldsEnd,
+		Version:       opts.Version,
+		BestOnly:      opts.BestOnly,
+		SkipSent:      opts.SkipSent,
+		MaxScores:     opts.Chunks.MaxScores,
+		MaxBytes:      opts.Chunks.MaxBytes,
+		Anomalies:     string(opts.AnomalyPolicy),
+		Format:        string(opts.Format),
+		ChartNotes:    chartNotes,
+		NoteMismatch:  noteMismatch,
+		Rules:         opts.RulesPath,
+		RulesChecksum: hex.EncodeToString(rulesChecksum[:]),
+	})
+	if err != nil {
+		return err
+	}
+
+	history, err := openHistory()
+	if err != nil {
+		return err
+	}
+	defer history.Close()
+
+	_, err = history.Exec(`
+		INSERT INTO export_history (exported_at, game, user_id, card, filters, options, scores, path, checksum)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, exportedAt.Format(time.RFC3339), game, userID, opts.Card, opts.Filter.String(), string(options), outcome.Stats.Exported, filepath.ToSlash(path), checksum)
+	if err != nil {
+		return fmt.Errorf("failed to record export history: %w", err)
+	}
+	return nil
+}
+
+func scanHistoryEntry(row interface{ Scan(...any) error }) (historyEntry, error) {
+	var entry historyEntry
+	var exportedAt, options string
+	err := row.Scan(&entry.ID, &exportedAt, &entry.Game, &entry.UserID, &entry.Card, &entry.Filters, &options, &entry.Scores, &entry.Path, &entry.Checksum)
+	if err != nil {
+		return entry, err
+	}
+	entry.ExportedAt, _ = time.Parse(time.RFC3339, exportedAt)
+	if err := json.Unmarshal([]byte(options), &entry.Options); err != nil {
+		return entry, fmt.Errorf("invalid options on history entry %d: %w", entry.ID, err)
+	}
+	return entry, nil
+}
+
+const historyColumns = "id, exported_at, game, user_id, card, filters, options, scores, path, checksum"
+
+// listExportHistory returns the newest exports first. Empty game or userID
+// match every game or user, a limit of 0 returns everything.
+func listExportHistory(game string, userID string, limit int) ([]historyEntry, error) {
+	history, err := openHistory()
+	if err != nil {
+		return nil, err
+	}
+	defer history.Close()
+
+	query := "SELECT " + historyColumns + " FROM export_history WHERE (? = '' OR game = ?) AND (? = '' OR user_id = ?) ORDER BY id DESC"
+	args := []any{game, game, userID, userID}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := history.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []historyEntry
+	for rows.Next() {
+		entry, err := scanHistoryEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func getExportHistory(id int64) (historyEntry, error) {
+	history, err := openHistory()
+	if err != nil {
+		return historyEntry{}, err
+	}
+	defer history.Close()
+
+	entry, err := scanHistoryEntry(history.QueryRow("SELECT "+historyColumns+" FROM export_history WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return entry, fmt.Errorf("no export with history ID %d", id)
+	}
+	return entry, err
+}
+
+// checkFiles works out the file status of every entry once, so listing them
+// does not hash the exports again
+func checkFiles(entries []historyEntry) {
+	for i := range entries {
+		entries[i].Status = entries[i].fileStatus()
+	}
+}
+
+// fileStatus compares the archived copy of the export with the checksum
+// taken when it was written
+func (e historyEntry) fileStatus() string {
+	checksum, err := exportChecksum(e.Path)
+	switch {
+	case os.IsNotExist(err):
+		return "missing"
+	case err != nil:
+		return "unreadable"
+	case checksum != e.Checksum:
+		return "changed since export"
+	default:
+		return "unchanged"
+	}
+}
+
+func (e historyEntry) String() string {
+	card := e.Card
+	if card == "" {
+		card = "by user ID"
+	}
+	var options []string
+	if e.Options.BestOnly {
+		options = append(options, "best-only")
+	}
+	if e.Options.SkipSent {
+		options = append(options, "skip-sent")
+	}
+	if e.Options.Version != "" {
+		options = append(options, "version="+e.Options.Version)
+	}
+	if e.Options.WorldsEnd != "" {
+		options = append(options, "worlds-end="+e.Options.WorldsEnd)
+	}
+	if e.Options.MaxScores > 0 {
+		options = append(options, fmt.Sprintf("max-scores=%d", e.Options.MaxScores))
+	}
+	if e.Options.MaxBytes > 0 {
+		options = append(options, fmt.Sprintf("max-bytes=%d", e.Options.MaxBytes))
+	}
+	if e.Options.Anomalies != "" && e.Options.Anomalies != string(anomalyExclude) {
+		options = append(options, "anomalies="+e.Options.Anomalies)
+	}
+	if e.Options.Format != "" && e.Options.Format != string(exportFormatTachi) {
+		options = append(options, "format="+e.Options.Format)
+	}
+	if e.Options.Rules != "" {
+		options = append(options, "rules="+e.Options.Rules)
+	}
+	if e.Options.ChartNotes != "" {
+		options = append(options, "chart-notes="+e.Options.ChartNotes, "note-mismatch="+e.Options.NoteMismatch)
+	}
+	if len(options) == 0 {
+		options = append(options, "none")
+	}
+
+	line := fmt.Sprintf("#%d %s  %s user %s (card %s)  %d scores  filters: %s  options: %s  %s",
+		e.ID, e.ExportedAt.Local().Format("2006-01-02 15:04"), e.Game, e.UserID, card, e.Scores,
+		e.Filters, strings.Join(options, " "), e.Path)
+	if e.Status != "" {
+		line += " [" + e.Status + "]"
+	}
+	return line
+}
+
+// regenerateExport runs a past export again with its original settings.
+// Scores already sent are not skipped this time, otherwise an export that
+// was uploaded would come back empty.
+func regenerateExport(db *sql.DB, entry historyEntry) (string, error) {
+	filter, err := parseExportFilter(entry.Filters)
+	if err != nil {
+		return "", err
+	}
+	rules, err := historyRules(entry)
+	if err != nil {
+		return "", err
+	}
+	var chartNotes chartNoteCounts
+	if entry.Options.ChartNotes != "" {
+		if chartNotes, err = loadChartNoteCounts(entry.Options.ChartNotes); err != nil {
+			return "", err
+		}
+	}
+
+	outcome, err := runExport(db, entry.Game, entry.UserID, exportOptions{
+		Filter:         filter,
+		Rules:          rules,
+		WorldsEnd:      entry.Options.WorldsEnd,
+		Version:        entry.Options.Version,
+		BestOnly:       entry.Options.BestOnly,
+		Chunks:         chunkLimits{MaxScores: entry.Options.MaxScores, MaxBytes: entry.Options.MaxBytes},
+		AnomalyPolicy:  anomalyPolicy(entry.Options.Anomalies),
+		ChartNotes:     chartNotes,
+		ChartNotesPath: entry.Options.ChartNotes,
+		NoteMismatch:   noteMismatchPolicy(entry.Options.NoteMismatch),
+		Format:         exportFormat(entry.Options.Format),
+		Card:           entry.Card,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	result := outcome.Result
+	if checksum, err := exportChecksum(outcome.Path); err == nil && checksum == entry.Checksum {
+		result += "\nThe new export is identical to the one from " + entry.ExportedAt.Local().Format("2006-01-02 15:04")
+	} else {
+		result += "\nThe new export differs from the one from " + entry.ExportedAt.Local().Format("2006-01-02 15:04")
+	}
+	return result, nil
+}
+
+// historyRules loads the exclusion rules a past export was made with from the
+// copy kept next to it. Entries recorded before the copy was kept fall back
+// to the rules file they named.
+func historyRules(entry historyEntry) (exclusionRules, error) {
+	path := filepath.Join(filepath.Dir(entry.Path), historyRulesFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) && entry.Options.RulesChecksum == "" {
+		return loadExclusionRules(entry.Options.Rules)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("the exclusion rules of this export are gone: %w", err)
+	}
+	if checksum := sha256.Sum256(data); hex.EncodeToString(checksum[:]) != entry.Options.RulesChecksum {
+		return nil, fmt.Errorf("%s changed since the export, it cannot be reproduced", path)
+	}
+	return loadExclusionRules(path)
+}
+
+// openExportFile opens a past export with the system's default application,
+// as long as it is still the file that was exported
+func openExportFile(entry historyEntry) error {
+	checksum, err := exportChecksum(entry.Path)
+	if err != nil {
+		return fmt.Errorf("%s is gone, regenerate the export instead: %w", entry.Path, err)
+	}
+	if checksum != entry.Checksum {
+		return fmt.Errorf("%s changed since it was exported, regenerate the export instead", entry.Path)
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", "", entry.Path)
+	case "darwin":
+		cmd = exec.Command("open", entry.Path)
+	default:
+		cmd = exec.Command("xdg-open", entry.Path)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to open %s: %w", entry.Path, err)
+	}
+	go cmd.Wait()
+	return nil
+}
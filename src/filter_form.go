@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+var filterFormLabels = []string{
+	"From date (YYYY-MM-DD)",
+	"To date (YYYY-MM-DD)",
+	"Difficulties (e.g. MASTER,ULTIMA)",
+	"Min romVersion (Chunithm)",
+	"Max romVersion (Chunithm)",
+	"Only music IDs",
+	"Exclude music IDs",
+}
+
+func newFilterInputs() []textinput.Model {
+	inputs := make([]textinput.Model, len(filterFormLabels))
+	for i := range inputs {
+		input := textinput.New()
+		input.CharLimit = 256
+		input.Width = 40
+		inputs[i] = input
+	}
+	return inputs
+}
+
+func (m model) openFilterForm() (tea.Model, tea.Cmd) {
+	m.view = "filterForm"
+	m.filterErr = ""
+	m.filterFocus = 0
+	for i := range m.filterInputs {
+		m.filterInputs[i].Blur()
+	}
+	return m, m.filterInputs[0].Focus()
+}
+
+// updateFilterForm handles key presses while the filter form is shown.
+// It runs before the global key handling so typing into a field is not
+// mistaken for a shortcut.
+func (m model) updateFilterForm(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c":
+		return m, tea.Quit
+	case "esc":
+		m.view = "userDisplay"
+		return m, nil
+	case "tab", "down", "shift+tab", "up":
+		m.filterInputs[m.filterFocus].Blur()
+		if msg.String() == "tab" || msg.String() == "down" {
+			m.filterFocus = (m.filterFocus + 1) % len(m.filterInputs)
+		} else {
+			m.filterFocus = (m.filterFocus + len(m.filterInputs) - 1) % len(m.filterInputs)
+		}
+		return m, m.filterInputs[m.filterFocus].Focus()
+	case "enter":
+		values := make([]string, len(m.filterInputs))
+		for i, input := range m.filterInputs {
+			values[i] = input.Value()
+		}
+		filter, err := newExportFilter(values[0], values[1], values[2], values[3], values[4], values[5], values[6])
+		if err != nil {
+			m.filterErr = err.Error()
+			return m, nil
+		}
+		m.filter = filter
+		m.view = "userDisplay"
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.filterInputs[m.filterFocus], cmd = m.filterInputs[m.filterFocus].Update(msg)
+	return m, cmd
+}
+
+func (m model) filterFormView() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Export filters for %s\n\n", m.selectedGame)
+	for i, input := range m.filterInputs {
+		fmt.Fprintf(&b, "%s\n%s\n\n", filterFormLabels[i], input.View())
+	}
+	if m.filterErr != "" {
+		fmt.Fprintf(&b, "Error: %s\n\n", m.filterErr)
+	}
+	b.WriteString("Tab/Shift+Tab to move, Enter to apply, Esc to cancel.")
+	return b.String()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// openHistoryView shows the past exports of the selected user and game
+func (m model) openHistoryView() (tea.Model, tea.Cmd) {
+	m.view = "history"
+	m.historyCursor = 0
+	m.historyStatus = ""
+	m.history, m.historyErr = nil, ""
+
+	entries, err := listExportHistory(m.selectedGame, m.userAimeCardInput.Value(), 0)
+	if err != nil {
+		m.historyErr = err.Error()
+		return m, nil
+	}
+	checkFiles(entries)
+	m.history = entries
+	return m, nil
+}
+
+// updateHistory handles key presses while the history view is shown
+func (m model) updateHistory(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		return m, tea.Quit
+	case "esc":
+		m.view = "userDisplay"
+		return m, nil
+	case "up", "k":
+		if m.historyCursor > 0 {
+			m.historyCursor--
+		}
+	case "down", "j":
+		if m.historyCursor < len(m.history)-1 {
+			m.historyCursor++
+		}
+	case "o":
+		if len(m.history) > 0 {
+			entry := m.history[m.historyCursor]
+			if err := openExportFile(entry); err != nil {
+				m.historyStatus = "Error: " + err.Error()
+			} else {
+				m.historyStatus = "Opened " + entry.Path
+			}
+		}
+	case "g":
+		if len(m.history) > 0 {
+			result, err := regenerateExport(m.db, m.history[m.historyCursor])
+			if err != nil {
+				m.historyStatus = "Error: " + err.Error()
+				return m, nil
+			}
+			// The regenerated export is recorded as a new entry at the top
+			next, cmd := m.openHistoryView()
+			updated := next.(model)
+			updated.historyStatus = result
+			return updated, cmd
+		}
+	}
+	return m, nil
+}
+
+func (m model) historyView() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Export history of user %s (%s)\n\n", m.userAimeCardInput.Value(), m.selectedGame)
+
+	switch {
+	case m.historyErr != "":
+		fmt.Fprintf(&b, "Error: %s\n\n", m.historyErr)
+	case len(m.history) == 0:
+		b.WriteString("No exports recorded yet.\n\n")
+	default:
+		for i, entry := range m.history {
+			cursor := "  "
+			if i == m.historyCursor {
+				cursor = "> "
+			}
+			b.WriteString(cursor + entry.String() + "\n")
+		}
+		b.WriteString("\n")
+	}
+
+	if m.historyStatus != "" {
+		b.WriteString(m.historyStatus + "\n\n")
+	}
+	b.WriteString("Up/Down to select, 'o' to open the file, 'g' to generate it again, Esc to go back.")
+	return b.String()
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	reportImprovementLimit = 25
+	reportChartHeight      = 120
+	reportBarWidth         = 18
+)
+
+// loadSongTitles reads song titles from the game's static music table.
+// Titles are optional, so servers without static data just get IDs.
+func loadSongTitles(db *sql.DB, game string) map[string]string {
+	titles := make(map[string]string)
+
+	var query string
+	switch game {
+	case "Chunithm":
+		query = "SELECT songId, title FROM chuni_static_music ORDER BY version"
+	case "Ongeki":
+		query = "SELECT songId, title FROM ongeki_static_music ORDER BY version"
+	default:
+		return titles
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return titles
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var songID sql.NullInt64
+		var title sql.NullString
+		if err := rows.Scan(&songID, &title); err != nil {
+			return titles
+		}
+		if songID.Valid && title.Valid {
+			titles[fmt.Sprintf("%d", songID.Int64)] = title.String
+		}
+	}
+	return titles
+}
+
+type reportBest struct {
+	chartBest
+	Title string
+}
+
+type reportLampCount struct {
+	Lamp    string
+	Count   int
+	Percent float64
+}
+
+type reportBar struct {
+	Label  string
+	Count  int
+	X      int
+	Y      int
+	Height int
+}
+
+type reportImprovement struct {
+	Chart    chartKey
+	Title    string
+	OldScore int
+	NewScore int
+	Date     string
+}
+
+type reportData struct {
+	Game         string
+	UserID       string
+	UserName     string
+	Generated    string
+	TotalPlays   int
+	Bests        []reportBest
+	Lamps        []reportLampCount
+	Months       []reportBar
+	FirstMonth   string
+	LastMonth    string
+	ChartWidth   int
+	ChartHeight  int
+	BarWidth     int
+	Improvements []reportImprovement
+}
+
+// buildReportData computes the report sections from the user's plays
+func buildReportData(game, userID, userName string, records []scoreRecord, titles map[string]string) reportData {
+	data := reportData{
+		Game:        game,
+		UserID:      userID,
+		UserName:    userName,
+		Generated:   time.Now().Format("2006-01-02 15:04"),
+		TotalPlays:  len(records),
+		ChartHeight: reportChartHeight,
+		BarWidth:    reportBarWidth,
+	}
+
+	// Personal bests and the distribution of their lamps
+	lampCounts := make(map[string]int)
+	lampRanks := make(map[string]int)
+	bests := personalBests(records)
+	for _, best := range bests {
+		data.Bests = append(data.Bests, reportBest{chartBest: best, Title: titles[best.Chart.Identifier]})
+		lampCounts[best.Lamp]++
+		lampRanks[best.Lamp] = best.LampRank
+	}
+	for lamp, count := range lampCounts {
+		data.Lamps = append(data.Lamps, reportLampCount{
+			Lamp:    lamp,
+			Count:   count,
+			Percent: float64(count) * 100 / float64(len(bests)),
+		})
+	}
+	sort.Slice(data.Lamps, func(i, j int) bool { return lampRanks[data.Lamps[i].Lamp] > lampRanks[data.Lamps[j].Lamp] })
+
+	// Plays per month as a bar chart
+	var months []string
+	monthCounts := make(map[string]int)
+	for _, record := range records {
+		if record.Time == nil {
+			continue
+		}
+		month := record.Time.Format("2006-01")
+		if monthCounts[month] == 0 {
+			months = append(months, month)
+		}
+		monthCounts[month]++
+	}
+	sort.Strings(months)
+	maxCount := 0
+	for _, month := range months {
+		maxCount = max(maxCount, monthCounts[month])
+	}
+	for i, month := range months {
+		height := monthCounts[month] * reportChartHeight / maxCount
+		data.Months = append(data.Months, reportBar{
+			Label:  month,
+			Count:  monthCounts[month],
+			X:      i * (reportBarWidth + 4),
+			Y:      reportChartHeight - height,
+			Height: height,
+		})
+	}
+	data.ChartWidth = max(len(months)*(reportBarWidth+4), reportBarWidth)
+	if len(months) > 0 {
+		data.FirstMonth = months[0]
+		data.LastMonth = months[len(months)-1]
+	}
+
+	// Plays that beat an earlier best on the same chart, newest first
+	running := make(map[chartKey]int)
+	for _, record := range records {
+		previous, played := running[record.chart()]
+		if played && record.Score > previous && record.Time != nil {
+			data.Improvements = append(data.Improvements, reportImprovement{
+				Chart:    record.chart(),
+				Title:    titles[record.Identifier],
+				OldScore: previous,
+				NewScore: record.Score,
+				Date:     record.Time.Format("2006-01-02"),
+			})
+		}
+		if !played || record.Score > previous {
+			running[record.chart()] = record.Score
+		}
+	}
+	sort.SliceStable(data.Improvements, func(i, j int) bool { return data.Improvements[i].Date > data.Improvements[j].Date })
+	if len(data.Improvements) > reportImprovementLimit {
+		data.Improvements = data.Improvements[:reportImprovementLimit]
+	}
+
+	return data
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"date": func(t *time.Time) string {
+		if t == nil {
+			return "-"
+		}
+		return t.Format("2006-01-02")
+	},
+	"lampClass": func(lamp string) string {
+		return strings.ToLower(strings.ReplaceAll(lamp, " ", "-"))
+	},
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.UserName}} - {{.Game}} records</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 960px; color: #222; background: #fafafa; }
+h1 { margin-bottom: 0; }
+.sub { color: #777; margin-top: 0.2em; }
+section { background: #fff; border: 1px solid #ddd; border-radius: 6px; padding: 1em; margin: 1em 0; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+th, td { text-align: left; padding: 0.25em 0.5em; border-bottom: 1px solid #eee; }
+td.num { text-align: right; font-variant-numeric: tabular-nums; }
+.bar { background: #d63384; height: 1em; display: inline-block; vertical-align: middle; }
+.lamp { padding: 0 0.4em; border-radius: 3px; font-size: 0.85em; background: #eee; }
+.failed, .loss { background: #f8d7da; }
+.clear { background: #d1e7dd; }
+.full-combo, .missless, .full-bell { background: #cfe2ff; }
+.all-justice, .all-break, .all-marvelous { background: #fff3cd; }
+.all-justice-critical { background: #ffe69c; }
+</style>
+</head>
+<body>
+<h1>{{.UserName}}</h1>
+<p class="sub">{{.Game}} &middot; user {{.UserID}} &middot; {{.TotalPlays}} plays on {{len .Bests}} charts &middot; generated {{.Generated}}</p>
+
+<section>
+<h2>Lamp distribution</h2>
+<table>
+{{range .Lamps}}<tr><td><span class="lamp {{lampClass .Lamp}}">{{.Lamp}}</span></td><td class="num">{{.Count}}</td><td style="width:60%"><span class="bar" style="width:{{printf "%.1f" .Percent}}%"></span></td></tr>
+{{end}}</table>
+</section>
+
+<section>
+<h2>Plays per month</h2>
+{{if .Months}}<svg width="{{.ChartWidth}}" height="{{.ChartHeight}}" viewBox="0 0 {{.ChartWidth}} {{.ChartHeight}}" xmlns="http://www.w3.org/2000/svg">
+{{range .Months}}<rect x="{{.X}}" y="{{.Y}}" width="{{$.BarWidth}}" height="{{.Height}}" fill="#d63384"><title>{{.Label}}: {{.Count}} plays</title></rect>
+{{end}}</svg>
+<p class="sub">{{.FirstMonth}} to {{.LastMonth}}</p>{{else}}<p>No dated plays.</p>{{end}}
+</section>
+
+<section>
+<h2>Recent improvements</h2>
+{{if .Improvements}}<table>
+<tr><th>Date</th><th>Song</th><th>Difficulty</th><th>Old</th><th>New</th></tr>
+{{range .Improvements}}<tr><td>{{.Date}}</td><td>{{if .Title}}{{.Title}}{{else}}#{{.Chart.Identifier}}{{end}}</td><td>{{.Chart.Difficulty}}</td><td class="num">{{.OldScore}}</td><td class="num">{{.NewScore}}</td></tr>
+{{end}}</table>{{else}}<p>No improvements recorded.</p>{{end}}
+</section>
+
+<section>
+<h2>Personal bests</h2>
+<table>
+<tr><th>ID</th><th>Song</th><th>Difficulty</th><th>Score</th><th>Lamp</th><th>Plays</th><th>Achieved</th></tr>
+{{range .Bests}}<tr><td>{{.Chart.Identifier}}</td><td>{{.Title}}</td><td>{{.Chart.Difficulty}}</td><td class="num">{{.Score}}</td><td><span class="lamp {{lampClass .Lamp}}">{{.Lamp}}</span></td><td class="num">{{.Plays}}</td><td>{{date .ScoreTime}}</td></tr>
+{{end}}</table>
+</section>
+</body>
+</html>
+`))
+
+// writeHTMLReport renders a self-contained report page for the user
+func writeHTMLReport(db *sql.DB, game string, userID string, userName string, path string) (string, error) {
+	records, err := fetchScoreRecords(db, game, userID)
+	if err != nil {
+		return "", err
+	}
+
+	data := buildReportData(game, userID, userName, records, loadSongTitles(db, game))
+
+	if path == "" {
+		if err := os.MkdirAll("exports", 0755); err != nil {
+			return "", fmt.Errorf("failed to create exports directory: %w", err)
+		}
+		path = fmt.Sprintf("exports/%s_report_%s.html", strings.ToLower(game), userID)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create report file: %w", err)
+	}
+	defer file.Close()
+
+	if err := reportTemplate.Execute(file, data); err != nil {
+		return "", fmt.Errorf("failed to render report: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// batchManualStream writes a batch-manual file one score at a time. The bytes
+// are the same json.MarshalIndent(export, "", " ") produces for the whole
+// export, but only the score being written is held in memory. The file is
+// written next to its destination and only renamed into place once finished,
+// so a failed export never leaves a truncated file behind.
+type batchManualStream struct {
+	path   string
+	file   *os.File
+	w      *bufio.Writer
+	scores int
+}
+
+func createBatchManualStream(path string, meta any) (*batchManualStream, error) {
+	file, err := os.Create(path + ".tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create export file: %w", err)
+	}
+	s := &batchManualStream{path: path, file: file, w: bufio.NewWriter(file)}
+
+	s.w.WriteString("{\n \"meta\": ")
+	if err := s.writeIndented(meta, " "); err != nil {
+		s.abort()
+		return nil, err
+	}
+	s.w.WriteString(",\n \"scores\": ")
+	return s, nil
+}
+
+func (s *batchManualStream) writeIndented(v any, prefix string) error {
+	data, err := json.MarshalIndent(v, prefix, " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal export data: %w", err)
+	}
+	_, err = s.w.Write(data)
+	return err
+}
+
+func (s *batchManualStream) writeScore(score any) error {
+	if s.scores == 0 {
+		s.w.WriteString("[\n  ")
+	} else {
+		s.w.WriteString(",\n  ")
+	}
+	s.scores++
+	return s.writeIndented(score, "  ")
+}
+
+// finish closes the scores array, writes the classes if there are any and
+// moves the file into place. An export without scores gets "scores": null,
+// the same as a nil slice.
+func (s *batchManualStream) finish(classes any) error {
+	if s.scores == 0 {
+		s.w.WriteString("null")
+	} else {
+		s.w.WriteString("\n ]")
+	}
+	if classes != nil {
+		s.w.WriteString(",\n \"classes\": ")
+		if err := s.writeIndented(classes, " "); err != nil {
+			s.abort()
+			return err
+		}
+	}
+	s.w.WriteString("\n}")
+
+	if err := s.w.Flush(); err != nil {
+		s.abort()
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := s.file.Close(); err != nil {
+		os.Remove(s.file.Name())
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	if err := os.Rename(s.file.Name(), s.path); err != nil {
+		os.Remove(s.file.Name())
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	return nil
+}
+
+// abort drops a partially written export
+func (s *batchManualStream) abort() {
+	s.file.Close()
+	os.Remove(s.file.Name())
+}
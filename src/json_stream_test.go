@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBatchManualStreamMatchesMarshalIndent(t *testing.T) {
+	timeAchieved := int64(1700000000)
+	dan, colour := "DAN_V", "RAINBOW"
+
+	judged := BatchManualScoreChuni{Identifier: "12", MatchType: "inGameID", Score: 1008000, Lamp: FullCombo, Difficulty: "MASTER", TimeAchieved: &timeAchieved}
+	judged.Judgements = &struct {
+		JCrit   int `json:"jcrit"`
+		Justice int `json:"justice"`
+		Attack  int `json:"attack"`
+		Miss    int `json:"miss"`
+	}{JCrit: 900, Justice: 12, Attack: 1}
+	judged.Optional = &struct {
+		MaxCombo int `json:"maxCombo"`
+	}{MaxCombo: 913}
+
+	tests := []struct {
+		name    string
+		scores  []BatchManualScoreChuni
+		classes *chuniClasses
+	}{
+		{"no scores", nil, nil},
+		{"no scores with classes", nil, &chuniClasses{Dan: &dan}},
+		{"one score", []BatchManualScoreChuni{{Identifier: "1", MatchType: "inGameID", Score: 950000, Lamp: Clear, Difficulty: "EXPERT"}}, nil},
+		{"several scores with classes", []BatchManualScoreChuni{
+			judged,
+			{Identifier: "7", MatchType: "inGameID", Score: 800000, Lamp: Failed, Difficulty: "BASIC", Comment: "flagged \"play\" <check>"},
+			judged,
+		}, &chuniClasses{Dan: &dan, Colour: &colour}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			export := BatchManualImportChuni{Scores: tt.scores, Classes: tt.classes}
+			export.Meta.Game = "chunithm"
+			export.Meta.Playtype = "Single"
+			export.Meta.Service = "Cozynet"
+			want, err := json.MarshalIndent(export, "", " ")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join(t.TempDir(), "export.json")
+			stream, err := createBatchManualStream(path, export.Meta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, score := range tt.scores {
+				if err := stream.writeScore(score); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// finish only writes classes given as a non-nil interface
+			var classes any
+			if tt.classes != nil {
+				classes = tt.classes
+			}
+			if err := stream.finish(classes); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("stream wrote\n%s\nwant\n%s", got, want)
+			}
+			if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+				t.Errorf("temporary file left behind: %v", err)
+			}
+		})
+	}
+}
+
+func TestBatchManualStreamAbort(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.json")
+	stream, err := createBatchManualStream(path, map[string]string{"game": "chunithm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.writeScore(BatchManualScoreChuni{Identifier: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	stream.abort()
+
+	for _, p := range []string{path, path + ".tmp"} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s exists after abort: %v", p, err)
+		}
+	}
+}
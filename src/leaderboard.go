@@ -0,0 +1,417 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	leaderboardImprovementLimit = 10
+	leaderboardWeek             = 7 * 24 * time.Hour
+)
+
+// chuniBestLevels are the level numbers of the current Chunithm best table,
+// DIFFICULTY_MAP does the same for Ongeki
+var chuniBestLevels = []string{"BASIC", "ADVANCED", "EXPERT", "MASTER", "ULTIMA", worldsEndDifficulty}
+
+type leaderboardEntry struct {
+	Rank     int    `json:"rank"`
+	UserID   int64  `json:"userId"`
+	Name     string `json:"name"`
+	Score    int    `json:"score"`
+	Lamp     string `json:"lamp"`
+	lampRank int
+}
+
+// leaderboard ranks every player of one chart by best score, then lamp
+type leaderboard struct {
+	Game       string             `json:"game"`
+	Identifier string             `json:"identifier"`
+	Title      string             `json:"title,omitempty"`
+	Difficulty string             `json:"difficulty"`
+	Entries    []leaderboardEntry `json:"entries"`
+}
+
+// leaderboardImprovement is a chart a player beat their earlier best on
+type leaderboardImprovement struct {
+	UserID     int64  `json:"userId"`
+	Name       string `json:"name"`
+	Identifier string `json:"identifier"`
+	Title      string `json:"title,omitempty"`
+	Difficulty string `json:"difficulty"`
+	Before     int    `json:"before"`
+	After      int    `json:"after"`
+	Gain       int    `json:"gain"`
+}
+
+func leaderboardLevels(game string) (map[int64]string, error) {
+	levels := make(map[int64]string)
+	switch game {
+	case "Chunithm":
+		for level, difficulty := range chuniBestLevels {
+			levels[int64(level)] = difficulty
+		}
+	case "Ongeki":
+		for level, difficulty := range DIFFICULTY_MAP {
+			levels[int64(level)] = difficulty
+		}
+	default:
+		return nil, fmt.Errorf("leaderboards are only supported for Chunithm and Ongeki")
+	}
+	return levels, nil
+}
+
+// loadPlayerNames maps user IDs to the name of their newest profile
+func loadPlayerNames(db *sql.DB, game string) (map[int64]string, error) {
+	var query string
+	switch game {
+	case "Chunithm":
+		query = "SELECT user, userName FROM chuni_profile_data ORDER BY version"
+	case "Ongeki":
+		query = "SELECT user, userName FROM ongeki_profile_data ORDER BY version"
+	default:
+		return nil, errInvalidGame
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch player names: %w", err)
+	}
+	defer rows.Close()
+
+	names := make(map[int64]string)
+	for rows.Next() {
+		var user int64
+		var name sql.NullString
+		if err := rows.Scan(&user, &name); err != nil {
+			return nil, err
+		}
+		if name.Valid {
+			names[user] = name.String
+		}
+	}
+	return names, rows.Err()
+}
+
+func fetchLeaderboard(db *sql.DB, game string, identifier string, difficulty string) (*leaderboard, error) {
+	levels, err := leaderboardLevels(game)
+	if err != nil {
+		return nil, err
+	}
+	level := int64(-1)
+	for l, name := range levels {
+		if strings.EqualFold(name, difficulty) {
+			level, difficulty = l, name
+		}
+	}
+	if level < 0 {
+		return nil, fmt.Errorf("unknown %s difficulty %q", game, difficulty)
+	}
+
+	var query string
+	switch game {
+	case "Chunithm":
+		query = "SELECT user, scoreMax, isSuccess, isFullCombo, isAllJustice, 0 FROM chuni_score_best WHERE musicId = ? AND level = ?"
+	case "Ongeki":
+		query = "SELECT user, techScoreMax, clearStatus, isFullCombo, isAllBreake, isFullBell FROM ongeki_score_best WHERE musicId = ? AND level = ?"
+	}
+	names, err := loadPlayerNames(db, game)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(query, identifier, level)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch leaderboard: %w", err)
+	}
+	defer rows.Close()
+
+	board := &leaderboard{Game: strings.ToLower(game), Identifier: identifier, Title: loadSongTitles(db, game)[identifier], Difficulty: difficulty, Entries: []leaderboardEntry{}}
+	for rows.Next() {
+		var entry leaderboardEntry
+		var clear, fullCombo, allClear, fullBell sql.NullInt64
+		if err := rows.Scan(&entry.UserID, &entry.Score, &clear, &fullCombo, &allClear, &fullBell); err != nil {
+			return nil, err
+		}
+		entry.Name = names[entry.UserID]
+
+		if game == "Chunithm" {
+			lamp := Failed
+			switch {
+			case allClear.Int64 > 0 && entry.Score >= chuniMaxScore:
+				lamp = AllJusticeCritical
+			case allClear.Int64 > 0:
+				lamp = AllJustice
+			case fullCombo.Int64 > 0:
+				lamp = FullCombo
+			case clear.Int64 > 0:
+				lamp = Clear
+			}
+			entry.Lamp, entry.lampRank = string(lamp), chuniLampRanks[lamp]
+		} else {
+			lamp := Loss
+			switch {
+			case allClear.Int64 > 0:
+				lamp = AllBreak
+			case fullCombo.Int64 > 0:
+				lamp = FullComboGeki
+			case fullBell.Int64 > 0:
+				lamp = FullBell
+			case clear.Int64 > 0:
+				lamp = ClearGeki
+			}
+			entry.Lamp, entry.lampRank = string(lamp), gekiLampRanks[lamp]
+		}
+		board.Entries = append(board.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(board.Entries, func(i, j int) bool {
+		a, b := board.Entries[i], board.Entries[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if a.lampRank != b.lampRank {
+			return a.lampRank > b.lampRank
+		}
+		return a.UserID < b.UserID
+	})
+	// Players with the same score and lamp share a rank
+	for i := range board.Entries {
+		board.Entries[i].Rank = i + 1
+		if i > 0 {
+			prev := board.Entries[i-1]
+			if prev.Score == board.Entries[i].Score && prev.lampRank == board.Entries[i].lampRank {
+				board.Entries[i].Rank = prev.Rank
+			}
+		}
+	}
+	return board, nil
+}
+
+// fetchWeeklyImprovements finds the biggest raises of an earlier best score
+// since the given time across all players. Charts first played in that time
+// have nothing to improve on and are left out, as are WORLD'S END charts,
+// which have no leaderboard.
+func fetchWeeklyImprovements(db *sql.DB, game string, since time.Time, limit int) ([]leaderboardImprovement, error) {
+	levels, err := leaderboardLevels(game)
+	if err != nil {
+		return nil, err
+	}
+
+	// Chunithm's level numbers depend on the rom version, so bests are kept
+	// per version here and folded per difficulty below
+	table, score, romVersion := "chuni_score_playlog", "score", "romVersion"
+	if game == "Ongeki" {
+		table, score, romVersion = "ongeki_score_playlog", "techScore", "''"
+	}
+	names, err := loadPlayerNames(db, game)
+	if err != nil {
+		return nil, err
+	}
+	titles := loadSongTitles(db, game)
+
+	rows, err := db.Query(fmt.Sprintf(`
+		SELECT user, musicId, level, %[3]s,
+			MAX(CASE WHEN userPlayDate < ? THEN %[2]s END) AS previousBest,
+			MAX(CASE WHEN userPlayDate >= ? THEN %[2]s END) AS weekBest
+		FROM %[1]s
+		GROUP BY user, musicId, level, %[3]s
+	`, table, score, romVersion), since.Format("2006-01-02 15:04:05"), since.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch improvements: %w", err)
+	}
+	defer rows.Close()
+
+	type improvementKey struct {
+		UserID     int64
+		MusicID    int64
+		Difficulty string
+	}
+	bests := make(map[improvementKey]*[2]sql.NullInt64)
+	var order []improvementKey
+	for rows.Next() {
+		var key improvementKey
+		var level int64
+		var rom sql.NullString
+		var before, after sql.NullInt64
+		if err := rows.Scan(&key.UserID, &key.MusicID, &level, &rom, &before, &after); err != nil {
+			return nil, err
+		}
+
+		if game == "Chunithm" {
+			version, err := parseChuniRomVersion(rom.String)
+			if err != nil || version.release() == nil {
+				continue
+			}
+			difficulty, ok := version.release().difficulty(level)
+			if !ok || difficulty == worldsEndDifficulty {
+				continue
+			}
+			key.Difficulty = difficulty
+		} else if key.Difficulty = levels[level]; key.Difficulty == "" {
+			continue
+		}
+
+		best, ok := bests[key]
+		if !ok {
+			best = &[2]sql.NullInt64{}
+			bests[key] = best
+			order = append(order, key)
+		}
+		for i, value := range []sql.NullInt64{before, after} {
+			if value.Valid && (!best[i].Valid || value.Int64 > best[i].Int64) {
+				best[i] = value
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	improvements := []leaderboardImprovement{}
+	for _, key := range order {
+		before, after := bests[key][0], bests[key][1]
+		if !before.Valid || !after.Valid || after.Int64 <= before.Int64 {
+			continue
+		}
+		identifier := strconv.FormatInt(key.MusicID, 10)
+		improvements = append(improvements, leaderboardImprovement{
+			UserID:     key.UserID,
+			Name:       names[key.UserID],
+			Identifier: identifier,
+			Title:      titles[identifier],
+			Difficulty: key.Difficulty,
+			Before:     int(before.Int64),
+			After:      int(after.Int64),
+			Gain:       int(after.Int64 - before.Int64),
+		})
+	}
+	sort.SliceStable(improvements, func(i, j int) bool {
+		if improvements[i].Gain != improvements[j].Gain {
+			return improvements[i].Gain > improvements[j].Gain
+		}
+		return improvements[i].UserID < improvements[j].UserID
+	})
+	if len(improvements) > limit {
+		improvements = improvements[:limit]
+	}
+	return improvements, nil
+}
+
+func (b *leaderboard) heading() string {
+	title := b.Title
+	if title == "" {
+		title = b.Identifier
+	}
+	return fmt.Sprintf("%s %s (%d players)", title, b.Difficulty, len(b.Entries))
+}
+
+func (e leaderboardEntry) String() string {
+	return fmt.Sprintf("%4d. %-10s %8d  %s", e.Rank, playerLabel(e.UserID, e.Name), e.Score, e.Lamp)
+}
+
+func (b *leaderboard) String() string {
+	var s strings.Builder
+	s.WriteString(b.heading() + "\n")
+	for _, entry := range b.Entries {
+		s.WriteString(entry.String() + "\n")
+	}
+	return s.String()
+}
+
+func formatImprovements(improvements []leaderboardImprovement) string {
+	if len(improvements) == 0 {
+		return "No improvements this week.\n"
+	}
+	var s strings.Builder
+	for i, improvement := range improvements {
+		title := improvement.Title
+		if title == "" {
+			title = improvement.Identifier
+		}
+		fmt.Fprintf(&s, "%4d. %-10s %s %s: %d -> %d (+%d)\n", i+1, playerLabel(improvement.UserID, improvement.Name), title, improvement.Difficulty, improvement.Before, improvement.After, improvement.Gain)
+	}
+	return s.String()
+}
+
+func playerLabel(userID int64, name string) string {
+	if name == "" {
+		return fmt.Sprintf("user %d", userID)
+	}
+	return name
+}
+
+// writeLeaderboard saves a leaderboard and the weekly improvements as JSON, or
+// as two CSV files since they have different columns. It returns the paths.
+func writeLeaderboard(board *leaderboard, improvements []leaderboardImprovement, format string) ([]string, error) {
+	if err := os.MkdirAll("exports", 0755); err != nil {
+		return nil, fmt.Errorf("failed to create exports directory: %w", err)
+	}
+	base := fmt.Sprintf("exports/leaderboard_%s_%s_%s", board.Game, board.Identifier, strings.NewReplacer("'", "", " ", "_").Replace(strings.ToLower(board.Difficulty)))
+
+	switch format {
+	case "json":
+		data, err := json.MarshalIndent(struct {
+			leaderboard
+			Improvements []leaderboardImprovement `json:"improvements"`
+		}{*board, improvements}, "", " ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal leaderboard: %w", err)
+		}
+		if err := os.WriteFile(base+".json", data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write leaderboard: %w", err)
+		}
+		return []string{base + ".json"}, nil
+
+	case "csv":
+		var entries [][]string
+		for _, entry := range board.Entries {
+			entries = append(entries, []string{strconv.Itoa(entry.Rank), strconv.FormatInt(entry.UserID, 10), entry.Name, strconv.Itoa(entry.Score), entry.Lamp})
+		}
+		if err := writeCSVFile(base+".csv", []string{"rank", "userId", "name", "score", "lamp"}, entries); err != nil {
+			return nil, err
+		}
+
+		var raises [][]string
+		for _, improvement := range improvements {
+			raises = append(raises, []string{
+				strconv.FormatInt(improvement.UserID, 10), improvement.Name, improvement.Identifier, improvement.Title,
+				improvement.Difficulty, strconv.Itoa(improvement.Before), strconv.Itoa(improvement.After), strconv.Itoa(improvement.Gain),
+			})
+		}
+		improvementsPath := fmt.Sprintf("exports/improvements_%s.csv", board.Game)
+		if err := writeCSVFile(improvementsPath, []string{"userId", "name", "identifier", "title", "difficulty", "before", "after", "gain"}, raises); err != nil {
+			return nil, err
+		}
+		return []string{base + ".csv", improvementsPath}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown leaderboard format %q (expected json or csv)", format)
+	}
+}
+
+func writeCSVFile(path string, header []string, records [][]string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write(header)
+	w.WriteAll(records)
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+type leaderboardMsg struct {
+	charts       []chartKey
+	index        int
+	board        *leaderboard
+	improvements []leaderboardImprovement
+	err          error
+}
+
+// fetchLeaderboardView loads the leaderboard of one of the charts the user
+// played. Without charts it first lists them along with the week's
+// improvements, which only change when the view is opened again.
+func fetchLeaderboardView(db *sql.DB, game string, userID string, charts []chartKey, index int) tea.Cmd {
+	return func() tea.Msg {
+		var improvements []leaderboardImprovement
+		if charts == nil {
+			records, err := fetchScoreRecords(db, game, userID)
+			if err != nil {
+				return leaderboardMsg{err: err}
+			}
+			charts = append([]chartKey{}, chartsByPlayCount(records)...)
+			improvements, err = fetchWeeklyImprovements(db, game, time.Now().Add(-leaderboardWeek), leaderboardImprovementLimit)
+			if err != nil {
+				return leaderboardMsg{err: err}
+			}
+		}
+		if len(charts) == 0 {
+			return leaderboardMsg{charts: charts, improvements: improvements}
+		}
+
+		chart := charts[index]
+		board, err := fetchLeaderboard(db, game, chart.Identifier, chart.Difficulty)
+		return leaderboardMsg{charts: charts, index: index, board: board, improvements: improvements, err: err}
+	}
+}
+
+func (m model) openLeaderboardView() (tea.Model, tea.Cmd) {
+	m.view = "leaderboard"
+	m.leaderboardCharts, m.leaderboardChart = nil, 0
+	m.leaderboard, m.improvements = nil, nil
+	m.leaderboardErr, m.leaderboardStatus = "", ""
+	return m, fetchLeaderboardView(m.db, m.selectedGame, m.userAimeCardInput.Value(), nil, 0)
+}
+
+func (m model) updateLeaderboardMsg(msg leaderboardMsg) (tea.Model, tea.Cmd) {
+	if msg.err != nil {
+		m.leaderboardErr = msg.err.Error()
+		return m, nil
+	}
+	m.leaderboardErr = ""
+	m.leaderboardCharts, m.leaderboardChart = msg.charts, msg.index
+	m.leaderboard = msg.board
+	if msg.improvements != nil {
+		m.improvements = msg.improvements
+	}
+	return m, nil
+}
+
+// updateLeaderboard handles key presses while the leaderboard view is shown
+func (m model) updateLeaderboard(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	charts := len(m.leaderboardCharts)
+	switch msg.String() {
+	case "ctrl+c", "q":
+		return m, tea.Quit
+	case "esc":
+		m.view = "userDisplay"
+		return m, nil
+	case "right", "l":
+		if charts > 0 {
+			m.leaderboardStatus = ""
+			return m, fetchLeaderboardView(m.db, m.selectedGame, m.userAimeCardInput.Value(), m.leaderboardCharts, (m.leaderboardChart+1)%charts)
+		}
+	case "left", "h":
+		if charts > 0 {
+			m.leaderboardStatus = ""
+			return m, fetchLeaderboardView(m.db, m.selectedGame, m.userAimeCardInput.Value(), m.leaderboardCharts, (m.leaderboardChart+charts-1)%charts)
+		}
+	case "j", "c":
+		if m.leaderboard != nil {
+			format := map[string]string{"j": "json", "c": "csv"}[msg.String()]
+			paths, err := writeLeaderboard(m.leaderboard, m.improvements, format)
+			if err != nil {
+				m.leaderboardStatus = "Error: " + err.Error()
+			} else {
+				m.leaderboardStatus = "Saved to " + strings.Join(paths, " and ")
+			}
+		}
+	}
+	return m, nil
+}
+
+func (m model) leaderboardView() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s leaderboards\n\n", m.selectedGame)
+
+	userID, _ := strconv.ParseInt(m.userAimeCardInput.Value(), 10, 64)
+	switch {
+	case m.leaderboardErr != "":
+		fmt.Fprintf(&b, "Error: %s\n\n", m.leaderboardErr)
+	case m.leaderboardCharts == nil:
+		b.WriteString("Loading...\n\n")
+	case len(m.leaderboardCharts) == 0:
+		b.WriteString("No plays found.\n\n")
+	case m.leaderboard != nil:
+		fmt.Fprintf(&b, "Chart %d/%d: %s\n", m.leaderboardChart+1, len(m.leaderboardCharts), m.leaderboard.heading())
+		for _, entry := range m.leaderboard.Entries {
+			// The selected user's own entry is marked
+			marker := "  "
+			if entry.UserID == userID {
+				marker = "> "
+			}
+			b.WriteString(marker + entry.String() + "\n")
+		}
+		b.WriteString("\n")
+	}
+
+	if m.improvements != nil {
+		b.WriteString("Top improvements this week:\n")
+		b.WriteString(formatImprovements(m.improvements))
+		b.WriteString("\n")
+	}
+
+	if m.leaderboardStatus != "" {
+		b.WriteString(m.leaderboardStatus + "\n\n")
+	}
+	b.WriteString("Left/Right to change chart, 'j' to save as JSON, 'c' as CSV, Esc to go back.")
+	return b.String()
+}
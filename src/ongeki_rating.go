@@ -0,0 +1,268 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ongekiBestFrameSize     = 45
+	ongekiRecentFrameSize   = 10
+	ongekiRecentCandidates  = 30
+	ongekiPlatinumFrameSize = 50
+)
+
+type ongekiChartKey struct {
+	MusicID    int64
+	Difficulty string
+}
+
+// ongekiChartRating is what a single score contributes to the rating
+type ongekiChartRating struct {
+	MusicID        int64
+	Difficulty     string
+	Constant       float64
+	Score          int
+	Lamp           BatchManualLampGeki
+	Rating         float64
+	PlatScore      int
+	PlatMax        int
+	PlatStars      int
+	PlatinumRating float64
+}
+
+type ongekiPlayerRating struct {
+	Rating   float64
+	Best     []ongekiChartRating
+	Recent   []ongekiChartRating
+	Platinum []ongekiChartRating
+	// HasPlatinum is set when the playlogs carry platinum scores, which only
+	// newer versions record
+	HasPlatinum    bool
+	PlatinumRating float64
+}
+
+// loadOngekiChartConstants reads chart constants from ongeki_static_music.
+// Rows of newer versions override older ones.
+func loadOngekiChartConstants(db *sql.DB) (map[ongekiChartKey]float64, error) {
+	rows, err := db.Query("SELECT songId, chartId, level FROM ongeki_static_music ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch chart constants: %w", err)
+	}
+	defer rows.Close()
+
+	constants := make(map[ongekiChartKey]float64)
+	for rows.Next() {
+		var songID, chartID sql.NullInt64
+		var level sql.NullFloat64
+		if err := rows.Scan(&songID, &chartID, &level); err != nil {
+			return nil, fmt.Errorf("failed to scan chart constant: %w", err)
+		}
+		if !songID.Valid || !chartID.Valid || !level.Valid {
+			continue
+		}
+		difficulty, ok := DIFFICULTY_MAP[int(chartID.Int64)]
+		if !ok {
+			continue
+		}
+		constants[ongekiChartKey{songID.Int64, difficulty}] = level.Float64
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return constants, nil
+}
+
+// ongekiPlayRating returns the rating of a technical score in hundredths,
+// including the FULL COMBO / ALL BREAK bonus
+func ongekiPlayRating(constant float64, techScore int, lamp BatchManualLampGeki) int {
+	c := int(constant*100 + 0.5)
+	s := techScore
+
+	var rating int
+	switch {
+	case s >= 1007500:
+		rating = c + 200
+	case s >= 1000000:
+		rating = c + 150 + (s-1000000)/150
+	case s >= 990000:
+		rating = c + 100 + (s-990000)/200
+	case s >= 970000:
+		rating = c + (s-970000)/200
+	case s >= 900000:
+		rating = c - 400 + (s-900000)/175
+	case s >= 800000:
+		rating = c - 600 + (s-800000)/500
+	}
+	if rating <= 0 {
+		return 0
+	}
+
+	switch lamp {
+	case AllBreak:
+		rating += 30
+	case FullComboGeki:
+		rating += 10
+	}
+	return rating
+}
+
+// ongekiPlatinumStars rates a platinum score from 0 to 5 stars by how close
+// it is to the maximum
+func ongekiPlatinumStars(platScore, platMax int) int {
+	if platMax <= 0 {
+		return 0
+	}
+	ratio := platScore * 1000 / platMax
+	switch {
+	case ratio >= 980:
+		return 5
+	case ratio >= 970:
+		return 4
+	case ratio >= 960:
+		return 3
+	case ratio >= 950:
+		return 2
+	case ratio >= 940:
+		return 1
+	}
+	return 0
+}
+
+// calculateOngekiRating rates the scores produced by fetchOngekiExport, which
+// are ordered by play date
+func calculateOngekiRating(scores []BatchManualScoreGeki, constants map[ongekiChartKey]float64) *ongekiPlayerRating {
+	var rating ongekiPlayerRating
+
+	best := make(map[ongekiChartKey]ongekiChartRating)
+	bestPlatinum := make(map[ongekiChartKey]ongekiChartRating)
+	var plays []ongekiChartRating
+	for _, score := range scores {
+		musicID, err := strconv.ParseInt(score.Identifier, 10, 64)
+		if err != nil {
+			continue
+		}
+		key := ongekiChartKey{musicID, score.Difficulty}
+		constant, ok := constants[key]
+		if !ok {
+			continue
+		}
+
+		play := ongekiChartRating{
+			MusicID:    musicID,
+			Difficulty: score.Difficulty,
+			Constant:   constant,
+			Score:      score.Score,
+			Lamp:       score.Lamp,
+			Rating:     float64(ongekiPlayRating(constant, score.Score, score.Lamp)) / 100,
+		}
+
+		// The maximum platinum score is two points per note
+		if score.Optional != nil && score.Judgements != nil && score.Optional.PlatScore > 0 {
+			rating.HasPlatinum = true
+			play.PlatScore = score.Optional.PlatScore
+			play.PlatMax = 2 * (score.Judgements.CBreak + score.Judgements.Break + score.Judgements.Hit + score.Judgements.Miss)
+			play.PlatStars = ongekiPlatinumStars(play.PlatScore, play.PlatMax)
+			play.PlatinumRating = float64(int(constant*constant*float64(play.PlatStars)/10)) / 100
+			if current, ok := bestPlatinum[key]; !ok || play.PlatScore > current.PlatScore {
+				bestPlatinum[key] = play
+			}
+		}
+
+		plays = append(plays, play)
+		if current, ok := best[key]; !ok || play.Rating > current.Rating {
+			best[key] = play
+		}
+	}
+
+	for _, play := range best {
+		rating.Best = append(rating.Best, play)
+	}
+	sort.SliceStable(rating.Best, func(i, j int) bool { return rating.Best[i].Rating > rating.Best[j].Rating })
+	if len(rating.Best) > ongekiBestFrameSize {
+		rating.Best = rating.Best[:ongekiBestFrameSize]
+	}
+
+	// The recent frame is approximated as the best of the latest plays
+	if len(plays) > ongekiRecentCandidates {
+		plays = plays[len(plays)-ongekiRecentCandidates:]
+	}
+	rating.Recent = append(rating.Recent, plays...)
+	sort.SliceStable(rating.Recent, func(i, j int) bool { return rating.Recent[i].Rating > rating.Recent[j].Rating })
+	if len(rating.Recent) > ongekiRecentFrameSize {
+		rating.Recent = rating.Recent[:ongekiRecentFrameSize]
+	}
+
+	var total int
+	for _, play := range rating.Best {
+		total += ongekiPlayRating(play.Constant, play.Score, play.Lamp)
+	}
+	for _, play := range rating.Recent {
+		total += ongekiPlayRating(play.Constant, play.Score, play.Lamp)
+	}
+	rating.Rating = float64(total/(ongekiBestFrameSize+ongekiRecentFrameSize)) / 100
+
+	if rating.HasPlatinum {
+		for _, play := range bestPlatinum {
+			rating.Platinum = append(rating.Platinum, play)
+		}
+		sort.SliceStable(rating.Platinum, func(i, j int) bool {
+			return rating.Platinum[i].PlatinumRating > rating.Platinum[j].PlatinumRating
+		})
+		if len(rating.Platinum) > ongekiPlatinumFrameSize {
+			rating.Platinum = rating.Platinum[:ongekiPlatinumFrameSize]
+		}
+		for _, play := range rating.Platinum {
+			rating.PlatinumRating += play.PlatinumRating
+		}
+	}
+
+	return &rating
+}
+
+// fetchOngekiPlayerRating rates every playlog row of the user
+func fetchOngekiPlayerRating(db *sql.DB, userID string) (*ongekiPlayerRating, error) {
+	constants, err := loadOngekiChartConstants(db)
+	if err != nil {
+		return nil, err
+	}
+	if len(constants) == 0 {
+		return nil, fmt.Errorf("no chart constants in ongeki_static_music")
+	}
+
+	tachiExport, _, err := fetchOngekiExport(db, userID, exportOptions{AnomalyPolicy: anomalyKeep})
+	if err != nil {
+		return nil, err
+	}
+
+	return calculateOngekiRating(tachiExport.Scores, constants), nil
+}
+
+// formatOngekiRatingSummary lists the rating frames and what each chart contributes
+func formatOngekiRatingSummary(rating *ongekiPlayerRating) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Rating: %.2f\n", rating.Rating)
+
+	writeFrame := func(title string, plays []ongekiChartRating) {
+		fmt.Fprintf(&b, "\n%s (%d charts)\n", title, len(plays))
+		for i, play := range plays {
+			fmt.Fprintf(&b, "%3d. %6d %-9s %5.1f  %7d  %-10s %6.2f\n", i+1, play.MusicID, play.Difficulty, play.Constant, play.Score, play.Lamp, play.Rating)
+		}
+	}
+	writeFrame("Best frame", rating.Best)
+	writeFrame("Recent frame", rating.Recent)
+
+	if rating.HasPlatinum {
+		fmt.Fprintf(&b, "\nPlatinum rating: %.2f\n", rating.PlatinumRating)
+		fmt.Fprintf(&b, "\nPlatinum frame (%d charts)\n", len(rating.Platinum))
+		for i, play := range rating.Platinum {
+			fmt.Fprintf(&b, "%3d. %6d %-9s %5.1f  %5d/%-5d %d* %6.2f\n", i+1, play.MusicID, play.Difficulty, play.Constant, play.PlatScore, play.PlatMax, play.PlatStars, play.PlatinumRating)
+		}
+	}
+
+	return b.String()
+}
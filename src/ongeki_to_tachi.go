@@ -47,6 +47,12 @@ type BatchManualScoreGeki struct {
 		TotalBellCount int `json:"totalBellCount"`
 		PlatScore      int `json:"platScore"`
 	} `json:"optional,omitempty"`
+
+	// Comment is shown on Tachi, only set to flag suspicious plays
+	Comment string `json:"comment,omitempty"`
+
+	// PlaylogID points anomaly reports back at the database row
+	PlaylogID int64 `json:"-"`
 }
 
 type BatchManualImportGeki struct {
@@ -58,16 +64,18 @@ type BatchManualImportGeki struct {
 	Scores []BatchManualScoreGeki `json:"scores"`
 }
 
-func fetchOngekiExport(db *sql.DB, userID string) (*BatchManualImportGeki, error) {
+func fetchOngekiExport(db *sql.DB, userID string, opts exportOptions) (*BatchManualImportGeki, exportStats, error) {
 	var tachiExport BatchManualImportGeki
+	var stats exportStats
 	tachiExport.Meta.Game = "ongeki"
 	tachiExport.Meta.Playtype = "Single"
 	tachiExport.Meta.Service = "batch-artemis-export"
 	tachiExport.Scores = []BatchManualScoreGeki{} // Initialize slice to avoid `null` in JSON
 
+	now := time.Now()
 	rows, err := db.Query(`
 		SELECT 
-			userPlayDate, musicId, clearStatus, level as difficulty,
+			id, userPlayDate, musicId, clearStatus, level as difficulty,
 			techScore, maxCombo, judgeMiss, judgeHit, judgeBreak,
 			judgeCriticalBreak, bellCount, damageCount, isFullCombo,
 			isFullBell, isAllBreak, platinumScore, totalBellCount
@@ -76,12 +84,13 @@ func fetchOngekiExport(db *sql.DB, userID string) (*BatchManualImportGeki, error
 		ORDER BY userPlayDate
 	`, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch playlog: %v", err)
+		return nil, stats, fmt.Errorf("failed to fetch playlog: %v", err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		var playlog struct {
+			ID                 int64
 			UserPlayDate       sql.NullString
 			MusicID            sql.NullInt32
 			ClearStatus        sql.NullInt32
@@ -102,14 +111,14 @@ func fetchOngekiExport(db *sql.DB, userID string) (*BatchManualImportGeki, error
 		}
 
 		err := rows.Scan(
-			&playlog.UserPlayDate, &playlog.MusicID, &playlog.ClearStatus, &playlog.Difficulty,
+			&playlog.ID, &playlog.UserPlayDate, &playlog.MusicID, &playlog.ClearStatus, &playlog.Difficulty,
 			&playlog.TechScore, &playlog.MaxCombo, &playlog.JudgeMiss, &playlog.JudgeHit,
 			&playlog.JudgeBreak, &playlog.JudgeCriticalBreak, &playlog.BellCount,
 			&playlog.DamageCount, &playlog.IsFullCombo, &playlog.IsFullBell,
 			&playlog.IsAllBreak, &playlog.PlatinumScore, &playlog.TotalBellCount,
 		)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan playlog row: %v", err)
+			return nil, stats, fmt.Errorf("failed to scan playlog row: %v", err)
 		}
 
 		// Determine lamp status
@@ -135,14 +144,29 @@ func fetchOngekiExport(db *sql.DB, userID string) (*BatchManualImportGeki, error
 
 		// Convert timestamps (handling possible NULL values)
 		var timeAchieved *int64
+		var playDate *time.Time
 		if playlog.UserPlayDate.Valid {
 			parsedTime, err := time.Parse("2006-01-02 15:04:05", playlog.UserPlayDate.String)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse userPlayDate: %v", err)
+				return nil, stats, fmt.Errorf("failed to parse userPlayDate: %v", err)
 			}
 			timestamp := parsedTime.UnixMilli() + TIME_OFFSET
 			timeAchieved = &timestamp
+			playDate = &parsedTime
+		}
+
+		// Ongeki playlogs carry no rom version, so that part of the filter never applies
+		if !opts.Filter.allowsPlay(playDate, difficulty, int64(playlog.MusicID.Int32), "") {
+			stats.Filtered++
+			continue
 		}
+
+		// Omnimix and custom charts could be matched to a different song on Tachi
+		if opts.Rules.excludes("ongeki", int64(playlog.MusicID.Int32)) {
+			stats.CustomExcluded++
+			continue
+		}
+
 		// Construct score object
 		score := BatchManualScoreGeki{
 			Identifier:   fmt.Sprintf("%d", playlog.MusicID.Int32),
@@ -151,6 +175,7 @@ func fetchOngekiExport(db *sql.DB, userID string) (*BatchManualImportGeki, error
 			Lamp:         lamp,
 			Difficulty:   difficulty,
 			TimeAchieved: timeAchieved,
+			PlaylogID:    playlog.ID,
 		}
 
 		// Add judgements
@@ -181,11 +206,35 @@ func fetchOngekiExport(db *sql.DB, userID string) (*BatchManualImportGeki, error
 			PlatScore:      int(playlog.PlatinumScore.Int32),
 		}
 
+		// Corrupt rows are excluded, fixed or kept as the anomaly policy says
+		issues, fixed := validateGekiScore(score, now)
+		score, ok = screenScore(opts, &stats, playlog.ID, score.Identifier, difficulty, issues, score, fixed)
+		if !ok {
+			continue
+		}
+		if opts.ChartNotes != nil {
+			score.Comment, ok = screenNoteCounts(opts, &stats, playlog.ID, score.Identifier, difficulty, opts.ChartNotes.checkGekiNotes(score))
+			if !ok {
+				continue
+			}
+		}
+
 		// Append the score to the list
 		tachiExport.Scores = append(tachiExport.Scores, score)
+		stats.Exported++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, stats, err
+	}
+
+	if opts.BestOnly {
+		tachiExport.Scores = collapseToBests(tachiExport.Scores)
+		stats.Collapsed = stats.Exported - len(tachiExport.Scores)
+		stats.Exported = len(tachiExport.Scores)
 	}
+	tachiExport.Scores = skipSentScores(opts.sent, tachiExport.Scores, &stats)
 
-	return &tachiExport, nil
+	return &tachiExport, stats, nil
 }
 
 func exportOngekiToTachi(tachiExportGeki *BatchManualImportGeki) error {
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ratingSummary computes the player rating and renders the frame breakdown
+func ratingSummary(db *sql.DB, game string, userID string) (string, error) {
+	switch game {
+	case "Chunithm":
+		rating, err := fetchChuniPlayerRating(db, userID)
+		if err != nil {
+			return "", err
+		}
+		return formatChuniRatingSummary(rating), nil
+	case "Ongeki":
+		rating, err := fetchOngekiPlayerRating(db, userID)
+		if err != nil {
+			return "", err
+		}
+		return formatOngekiRatingSummary(rating), nil
+	default:
+		return "", fmt.Errorf("rating is not supported for %s", game)
+	}
+}
+
+// writeRatingSummary saves the summary to exports/<game>_rating_summary.txt
+func writeRatingSummary(db *sql.DB, game string, userID string) (string, error) {
+	summary, err := ratingSummary(db, game, userID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll("exports", 0755); err != nil {
+		return "", fmt.Errorf("failed to create exports directory: %w", err)
+	}
+
+	path := fmt.Sprintf("exports/%s_rating_summary.txt", strings.ToLower(game))
+	if err := os.WriteFile(path, []byte(summary), 0644); err != nil {
+		return "", fmt.Errorf("failed to write rating summary: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportFormat is the file format of an export. Tachi batch-manual JSON is
+// the default, CSV and NDJSON are for spreadsheets and jq.
+type exportFormat string
+
+const (
+	exportFormatTachi  exportFormat = "tachi"
+	exportFormatCSV    exportFormat = "csv"
+	exportFormatNDJSON exportFormat = "ndjson"
+)
+
+func parseExportFormat(format string) (exportFormat, error) {
+	switch exportFormat(format) {
+	case "", exportFormatTachi:
+		return exportFormatTachi, nil
+	case exportFormatCSV, exportFormatNDJSON:
+		return exportFormat(format), nil
+	default:
+		return "", fmt.Errorf("unknown export format %q (expected tachi, csv or ndjson)", format)
+	}
+}
+
+// nextExportFormat cycles tachi -> csv -> ndjson for the TUI
+func nextExportFormat(format exportFormat) exportFormat {
+	switch format {
+	case exportFormatCSV:
+		return exportFormatNDJSON
+	case exportFormatNDJSON:
+		return exportFormatTachi
+	default:
+		return exportFormatCSV
+	}
+}
+
+// recordColumns are the columns of a game's CSV export and the keys of its
+// NDJSON objects, in that order. Renaming one breaks people's sheets and jq
+// scripts, so only ever add to the end.
+func recordColumns(game string) []string {
+	columns := []string{"playlogId", "identifier", "difficulty", "score", "lamp", "playedAt", "romVersion", "maxCombo"}
+	return append(columns, recordJudgementColumns[game]...)
+}
+
+// recordValues lists a record's values in recordColumns order. Unknown values
+// are nil, which CSV writes as an empty cell and NDJSON as null.
+func recordValues(game string, record scoreRecord) []any {
+	var playedAt, romVersion any
+	if record.Time != nil {
+		playedAt = record.Time.UTC().Format(time.RFC3339)
+	}
+	if record.RomVersion != "" {
+		romVersion = record.RomVersion
+	}
+	values := []any{record.PlaylogID, record.Identifier, record.Difficulty, record.Score, record.Lamp, playedAt, romVersion, record.MaxCombo}
+	for i := range recordJudgementColumns[game] {
+		if record.Judgements != nil {
+			values = append(values, record.Judgements[i])
+		} else {
+			values = append(values, nil)
+		}
+	}
+	return values
+}
+
+// writeScoreRecords writes records to exports/<name>.csv or .ndjson and
+// returns the path
+func writeScoreRecords(name string, game string, records []scoreRecord, format exportFormat) (string, error) {
+	if err := os.MkdirAll("exports", 0755); err != nil {
+		return "", fmt.Errorf("failed to create exports directory: %w", err)
+	}
+	path := fmt.Sprintf("exports/%s.%s", name, format)
+	file, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	defer file.Close()
+
+	columns := recordColumns(game)
+	switch format {
+	case exportFormatCSV:
+		w := csv.NewWriter(file)
+		w.Write(columns)
+		for _, record := range records {
+			var row []string
+			for _, value := range recordValues(game, record) {
+				row = append(row, csvValue(value))
+			}
+			w.Write(row)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", path, err)
+		}
+
+	case exportFormatNDJSON:
+		w := bufio.NewWriter(file)
+		for _, record := range records {
+			// Built by hand so the keys keep the column order
+			var fields []string
+			for i, value := range recordValues(game, record) {
+				data, err := json.Marshal(value)
+				if err != nil {
+					return "", fmt.Errorf("failed to encode %s: %w", columns[i], err)
+				}
+				fields = append(fields, strconv.Quote(columns[i])+":"+string(data))
+			}
+			w.WriteString("{" + strings.Join(fields, ",") + "}\n")
+		}
+		if err := w.Flush(); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", path, err)
+		}
+
+	default:
+		return "", fmt.Errorf("%s is not a record format", format)
+	}
+
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return path, nil
+}
+
+func csvValue(value any) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const defaultSentStatePath = "exports/sent_scores.json"
+
+// tachiScoreHash identifies a score the way Tachi's score IDs do: an "R"
+// followed by the SHA-256 of the fields that make a score unique. Tachi hashes
+// its own user and chart IDs, which an export does not know, so the game,
+// playtype and chart identifier stand in for them.
+func tachiScoreHash(game, playtype, identifier, difficulty string, score int, lamp string, timeAchieved *int64) string {
+	timestamp := "null"
+	if timeAchieved != nil {
+		timestamp = strconv.FormatInt(*timeAchieved, 10)
+	}
+	elements := []string{game, playtype, identifier, difficulty, strconv.Itoa(score), lamp, timestamp}
+	sum := sha256.Sum256([]byte(strings.Join(elements, "|")))
+	return "R" + hex.EncodeToString(sum[:])
+}
+
+func (s BatchManualScoreChuni) dedupeHash() string {
+	return tachiScoreHash("chunithm", "Single", s.Identifier, s.Difficulty, s.Score, string(s.Lamp), s.TimeAchieved)
+}
+
+func (s BatchManualScoreGeki) dedupeHash() string {
+	return tachiScoreHash("ongeki", "Single", s.Identifier, s.Difficulty, s.Score, string(s.Lamp), s.TimeAchieved)
+}
+
+func (s BatchManualScoreWacca) dedupeHash() string {
+	return tachiScoreHash("wacca", "Single", s.Identifier, s.Difficulty, s.Score, string(s.Lamp), s.TimeAchieved)
+}
+
+// sentScores holds the hashes of scores already exported for one user and
+// game. Hashes of the running export are only written to the state file by
+// save, once the export itself was written.
+type sentScores struct {
+	path    string
+	game    string
+	userID  string
+	state   map[string]map[string][]string
+	known   map[string]bool
+	pending []string
+}
+
+func loadSentScores(path string, game string, userID string) (*sentScores, error) {
+	if path == "" {
+		path = defaultSentStatePath
+	}
+	sent := &sentScores{path: path, game: game, userID: userID, state: make(map[string]map[string][]string), known: make(map[string]bool)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read sent score state: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &sent.state); err != nil {
+			return nil, fmt.Errorf("failed to parse sent score state %s: %w", path, err)
+		}
+	}
+
+	for _, hash := range sent.state[game][userID] {
+		sent.known[hash] = true
+	}
+	return sent, nil
+}
+
+// check reports whether the score was sent before and remembers it otherwise.
+// Identical plays within one export are all kept, as they are in the playlog.
+func (s *sentScores) check(hash string) bool {
+	if s.known[hash] {
+		return true
+	}
+	s.pending = append(s.pending, hash)
+	return false
+}
+
+func (s *sentScores) save() error {
+	if len(s.pending) == 0 {
+		return nil
+	}
+
+	hashes := make([]string, 0, len(s.known)+len(s.pending))
+	for hash := range s.known {
+		hashes = append(hashes, hash)
+	}
+	for _, hash := range s.pending {
+		if !s.known[hash] {
+			s.known[hash] = true
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	s.pending = nil
+
+	if s.state[s.game] == nil {
+		s.state[s.game] = make(map[string][]string)
+	}
+	s.state[s.game][s.userID] = hashes
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	data, err := json.MarshalIndent(s.state, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal sent score state: %w", err)
+	}
+	if err := os.WriteFile(s.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write sent score state: %w", err)
+	}
+	return nil
+}
+
+// skipSentScores drops the scores exported before, counting them in stats
+func skipSentScores[T interface{ dedupeHash() string }](sent *sentScores, scores []T, stats *exportStats) []T {
+	if sent == nil || scores == nil {
+		return scores
+	}
+
+	kept := make([]T, 0, len(scores))
+	for _, score := range scores {
+		if sent.check(score.dedupeHash()) {
+			stats.AlreadySent++
+			continue
+		}
+		kept = append(kept, score)
+	}
+	stats.Exported = len(kept)
+	return kept
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// scoreRecord is a game independent view of an exported score, used by the
+// reports, stats and diffs
+type scoreRecord struct {
+	Identifier string
+	Difficulty string
+	Score      int
+	Lamp       string
+	LampRank   int
+	// Time is the play date as stored in the playlog, nil when unknown
+	Time *time.Time
+
+	// The playlog row and raw counts, only used by the CSV and NDJSON exports.
+	// Judgements are in the order of the game's recordJudgementColumns.
+	PlaylogID  int64
+	RomVersion string
+	MaxCombo   int
+	Judgements []int
+}
+
+// recordJudgementColumns are the playlog columns each game's raw judgement
+// counts come from
+var recordJudgementColumns = map[string][]string{
+	"chunithm": {"judgeHeaven", "judgeCritical", "judgeJustice", "judgeAttack", "judgeGuilty"},
+	"ongeki":   {"judgeCriticalBreak", "judgeBreak", "judgeHit", "judgeMiss"},
+	"wacca":    {"marv_ct", "great_ct", "good_ct", "miss_ct"},
+}
+
+type chartKey struct {
+	Identifier string
+	Difficulty string
+}
+
+func (r scoreRecord) chart() chartKey {
+	return chartKey{r.Identifier, r.Difficulty}
+}
+
+// Lamp ranks order each game's lamps from worst to best
+var chuniLampRanks = map[BatchManualLampChuni]int{Failed: 0, Clear: 1, FullCombo: 2, AllJustice: 3, AllJusticeCritical: 4}
+var gekiLampRanks = map[BatchManualLampGeki]int{Loss: 0, ClearGeki: 1, FullBell: 2, FullComboGeki: 3, AllBreak: 4}
+var waccaLampRanks = map[BatchManualLampWacca]int{FailedWacca: 0, ClearWacca: 1, Missless: 2, FullComboWacca: 3, AllMarvelous: 4}
+
+func chuniScoreRecords(scores []BatchManualScoreChuni) []scoreRecord {
+	records := make([]scoreRecord, 0, len(scores))
+	for _, score := range scores {
+		record := scoreRecord{
+			Identifier: score.Identifier,
+			Difficulty: score.Difficulty,
+			Score:      score.Score,
+			Lamp:       string(score.Lamp),
+			LampRank:   chuniLampRanks[score.Lamp],
+			PlaylogID:  score.PlaylogID,
+			RomVersion: score.RomVersion,
+		}
+		if score.Optional != nil {
+			record.MaxCombo = score.Optional.MaxCombo
+		}
+		if score.RawJudgements != nil {
+			record.Judgements = score.RawJudgements[:]
+		}
+		// Chunithm exports use seconds
+		if score.TimeAchieved != nil {
+			t := time.Unix(*score.TimeAchieved, 0).UTC()
+			record.Time = &t
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func gekiScoreRecords(scores []BatchManualScoreGeki) []scoreRecord {
+	records := make([]scoreRecord, 0, len(scores))
+	for _, score := range scores {
+		record := scoreRecord{
+			Identifier: score.Identifier,
+			Difficulty: score.Difficulty,
+			Score:      score.Score,
+			Lamp:       string(score.Lamp),
+			LampRank:   gekiLampRanks[score.Lamp],
+			PlaylogID:  score.PlaylogID,
+		}
+		if score.Optional != nil {
+			record.MaxCombo = score.Optional.MaxCombo
+		}
+		if j := score.Judgements; j != nil {
+			record.Judgements = []int{j.CBreak, j.Break, j.Hit, j.Miss}
+		}
+		// Ongeki exports use milliseconds shifted by TIME_OFFSET
+		if score.TimeAchieved != nil {
+			t := time.UnixMilli(*score.TimeAchieved - TIME_OFFSET).UTC()
+			record.Time = &t
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func waccaScoreRecords(scores []BatchManualScoreWacca) []scoreRecord {
+	records := make([]scoreRecord, 0, len(scores))
+	for _, score := range scores {
+		record := scoreRecord{
+			Identifier: score.Identifier,
+			Difficulty: score.Difficulty,
+			Score:      score.Score,
+			Lamp:       string(score.Lamp),
+			LampRank:   waccaLampRanks[score.Lamp],
+			PlaylogID:  score.PlaylogID,
+		}
+		if score.Optional != nil {
+			record.MaxCombo = score.Optional.MaxCombo
+		}
+		if j := score.Judgements; j != nil {
+			record.Judgements = []int{j.Marvelous, j.Great, j.Good, j.Miss}
+		}
+		if score.TimeAchieved != nil {
+			t := time.UnixMilli(*score.TimeAchieved).UTC()
+			record.Time = &t
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+// fetchScoreRecords runs the game's exporter without filters and returns its
+// scores in play order. Anomalous plays are kept, the validation rules only
+// decide what is uploaded.
+func fetchScoreRecords(db *sql.DB, game string, userID string) ([]scoreRecord, error) {
+	var records []scoreRecord
+	switch game {
+	case "Chunithm":
+		tachiExport, _, err := fetchChuniTachiExport(db, userID, exportOptions{AnomalyPolicy: anomalyKeep})
+		if err != nil {
+			return nil, err
+		}
+		records = chuniScoreRecords(tachiExport.Scores)
+	case "Ongeki":
+		tachiExport, _, err := fetchOngekiExport(db, userID, exportOptions{AnomalyPolicy: anomalyKeep})
+		if err != nil {
+			return nil, err
+		}
+		records = gekiScoreRecords(tachiExport.Scores)
+	case "WACCA":
+		tachiExport, _, err := fetchWaccaExport(db, userID, exportOptions{AnomalyPolicy: anomalyKeep})
+		if err != nil {
+			return nil, err
+		}
+		records = waccaScoreRecords(tachiExport.Scores)
+	default:
+		return nil, fmt.Errorf("scores are not supported for %s", game)
+	}
+
+	sortRecordsByTime(records)
+	return records, nil
+}
+
+// sortRecordsByTime orders records chronologically, undated ones first
+func sortRecordsByTime(records []scoreRecord) {
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Time == nil || records[j].Time == nil {
+			return records[i].Time == nil && records[j].Time != nil
+		}
+		return records[i].Time.Before(*records[j].Time)
+	})
+}
+
+// chartBest is the personal best on one chart. The best lamp may come from a
+// different play than the best score.
+type chartBest struct {
+	Chart     chartKey
+	Score     int
+	Lamp      string
+	LampRank  int
+	ScoreTime *time.Time
+	Plays     int
+}
+
+// personalBests collapses records into one entry per chart, sorted by chart
+func personalBests(records []scoreRecord) []chartBest {
+	bests := make(map[chartKey]*chartBest)
+	for _, record := range records {
+		best, ok := bests[record.chart()]
+		if !ok {
+			best = &chartBest{Chart: record.chart(), Score: -1, LampRank: -1}
+			bests[record.chart()] = best
+		}
+		best.Plays++
+		if record.Score > best.Score {
+			best.Score = record.Score
+			best.ScoreTime = record.Time
+		}
+		if record.LampRank > best.LampRank {
+			best.Lamp = record.Lamp
+			best.LampRank = record.LampRank
+		}
+	}
+
+	result := make([]chartBest, 0, len(bests))
+	for _, best := range bests {
+		result = append(result, *best)
+	}
+	sort.Slice(result, func(i, j int) bool { return lessChart(result[i].Chart, result[j].Chart) })
+	return result
+}
+
+// lessChart sorts charts by numeric identifier, then difficulty
+func lessChart(a, b chartKey) bool {
+	if len(a.Identifier) != len(b.Identifier) {
+		return len(a.Identifier) < len(b.Identifier)
+	}
+	if a.Identifier != b.Identifier {
+		return a.Identifier < b.Identifier
+	}
+	return a.Difficulty < b.Difficulty
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// anomalyPolicy decides what happens to plays that fail validation
+type anomalyPolicy string
+
+const (
+	anomalyExclude anomalyPolicy = "exclude"
+	anomalyFix     anomalyPolicy = "fix"
+	anomalyKeep    anomalyPolicy = "keep"
+)
+
+func parseAnomalyPolicy(policy string) (anomalyPolicy, error) {
+	switch anomalyPolicy(policy) {
+	case "", anomalyExclude:
+		return anomalyExclude, nil
+	case anomalyFix, anomalyKeep:
+		return anomalyPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown anomaly policy %q (expected exclude, fix or keep)", policy)
+	}
+}
+
+// nextAnomalyPolicy cycles exclude -> fix -> keep for the TUI
+func nextAnomalyPolicy(policy anomalyPolicy) anomalyPolicy {
+	switch policy {
+	case anomalyFix:
+		return anomalyKeep
+	case anomalyKeep:
+		return anomalyExclude
+	default:
+		return anomalyFix
+	}
+}
+
+// The dates the games launched, anything played earlier is a broken date
+var (
+	chuniFirstPlay = time.Date(2015, 7, 16, 0, 0, 0, 0, time.UTC)
+	gekiFirstPlay  = time.Date(2018, 7, 26, 0, 0, 0, 0, time.UTC)
+)
+
+const (
+	chuniMaxScore = 1010000
+	gekiMaxScore  = 1010000
+	// chuniScoreTolerance allows for the game rounding each note's score
+	chuniScoreTolerance = 1000
+)
+
+type anomalyIssue struct {
+	Rule   string `json:"rule"`
+	Detail string `json:"detail"`
+}
+
+type anomaly struct {
+	PlaylogID  int64          `json:"playlogId"`
+	Identifier string         `json:"identifier"`
+	Difficulty string         `json:"difficulty"`
+	Issues     []anomalyIssue `json:"issues"`
+	Action     string         `json:"action"`
+}
+
+// anomalyReport collects the flagged plays of one export
+type anomalyReport struct {
+	Game      string        `json:"game"`
+	Policy    anomalyPolicy `json:"policy"`
+	Anomalies []anomaly     `json:"anomalies"`
+}
+
+func checkPlayDate(playedAt time.Time, firstPlay time.Time, now time.Time) *anomalyIssue {
+	// A day of slack covers servers and cabinets in different time zones
+	if playedAt.Before(firstPlay) || playedAt.After(now.Add(24*time.Hour)) {
+		return &anomalyIssue{"date-range", fmt.Sprintf("played at %s", playedAt.UTC().Format("2006-01-02 15:04:05"))}
+	}
+	return nil
+}
+
+func checkScoreRange(score int, maxScore int) *anomalyIssue {
+	if score < 0 || score > maxScore {
+		return &anomalyIssue{"score-range", fmt.Sprintf("score %d outside 0-%d", score, maxScore)}
+	}
+	return nil
+}
+
+// validateChuniScore checks one Chunithm play and returns its problems along
+// with a fixed copy: scores are clamped, broken dates are dropped and
+// judgements that do not add up are left out rather than guessed
+func validateChuniScore(score BatchManualScoreChuni, now time.Time) ([]anomalyIssue, BatchManualScoreChuni) {
+	var issues []anomalyIssue
+	fixed := score
+
+	if issue := checkScoreRange(score.Score, chuniMaxScore); issue != nil {
+		issues = append(issues, *issue)
+		fixed.Score = min(max(score.Score, 0), chuniMaxScore)
+	}
+
+	if score.TimeAchieved != nil {
+		if issue := checkPlayDate(time.Unix(*score.TimeAchieved, 0), chuniFirstPlay, now); issue != nil {
+			issues = append(issues, *issue)
+			fixed.TimeAchieved = nil
+		}
+	}
+
+	if j := score.Judgements; j != nil {
+		total := j.JCrit + j.Justice + j.Attack + j.Miss
+		var judgementIssue *anomalyIssue
+		switch {
+		case min(j.JCrit, j.Justice, j.Attack, j.Miss) < 0:
+			judgementIssue = &anomalyIssue{"judgements", "negative judgement count"}
+		case total == 0:
+			judgementIssue = &anomalyIssue{"judgements", "no judgements recorded"}
+		default:
+			// JUSTICE CRITICAL is worth 101%, JUSTICE 100% and ATTACK 50% of a note
+			expected := (j.JCrit*1010000 + j.Justice*1000000 + j.Attack*500000) / total
+			if diff := expected - score.Score; diff > chuniScoreTolerance || diff < -chuniScoreTolerance {
+				judgementIssue = &anomalyIssue{"judgements", fmt.Sprintf("judgements add up to %d but the score is %d", expected, score.Score)}
+			}
+		}
+		if judgementIssue != nil {
+			issues = append(issues, *judgementIssue)
+			fixed.Judgements = nil
+		} else {
+			if score.Optional != nil && score.Optional.MaxCombo > total {
+				issues = append(issues, anomalyIssue{"max-combo", fmt.Sprintf("max combo %d above %d notes", score.Optional.MaxCombo, total)})
+				fixed.Optional = nil
+			}
+			if lamp := chuniLampFromJudgements(score.Lamp, j.Attack, j.Miss); lamp != score.Lamp {
+				issues = append(issues, anomalyIssue{"lamp", fmt.Sprintf("%s with %d attacks and %d misses", score.Lamp, j.Attack, j.Miss)})
+				fixed.Lamp = lamp
+			}
+		}
+	}
+
+	return issues, fixed
+}
+
+// chuniLampFromJudgements lowers a lamp the judgements do not support
+func chuniLampFromJudgements(lamp BatchManualLampChuni, attack, miss int) BatchManualLampChuni {
+	switch {
+	case miss > 0 && chuniLampRanks[lamp] >= chuniLampRanks[FullCombo]:
+		return Clear
+	case attack > 0 && chuniLampRanks[lamp] >= chuniLampRanks[AllJustice]:
+		return FullCombo
+	default:
+		return lamp
+	}
+}
+
+// validateGekiScore checks one Ongeki play, fixing it like validateChuniScore
+func validateGekiScore(score BatchManualScoreGeki, now time.Time) ([]anomalyIssue, BatchManualScoreGeki) {
+	var issues []anomalyIssue
+	fixed := score
+
+	if issue := checkScoreRange(score.Score, gekiMaxScore); issue != nil {
+		issues = append(issues, *issue)
+		fixed.Score = min(max(score.Score, 0), gekiMaxScore)
+	}
+
+	if score.TimeAchieved != nil {
+		if issue := checkPlayDate(time.UnixMilli(*score.TimeAchieved-TIME_OFFSET), gekiFirstPlay, now); issue != nil {
+			issues = append(issues, *issue)
+			fixed.TimeAchieved = nil
+		}
+	}
+
+	if j := score.Judgements; j != nil {
+		total := j.CBreak + j.Break + j.Hit + j.Miss
+		switch {
+		case min(j.CBreak, j.Break, j.Hit, j.Miss) < 0:
+			issues = append(issues, anomalyIssue{"judgements", "negative judgement count"})
+			fixed.Judgements = nil
+		case total == 0:
+			issues = append(issues, anomalyIssue{"judgements", "no judgements recorded"})
+			fixed.Judgements = nil
+		default:
+			if score.Optional != nil && score.Optional.MaxCombo > total {
+				issues = append(issues, anomalyIssue{"max-combo", fmt.Sprintf("max combo %d above %d notes", score.Optional.MaxCombo, total)})
+				fixed.Optional = nil
+			}
+			bellsFull := score.Optional == nil || score.Optional.BellCount >= score.Optional.TotalBellCount
+			if lamp := gekiLampFromJudgements(score.Lamp, j.Hit, j.Miss, bellsFull); lamp != score.Lamp {
+				issues = append(issues, anomalyIssue{"lamp", fmt.Sprintf("%s with %d hits and %d misses", score.Lamp, j.Hit, j.Miss)})
+				fixed.Lamp = lamp
+			}
+		}
+	}
+
+	if o := score.Optional; o != nil && (o.BellCount < 0 || o.BellCount > o.TotalBellCount) {
+		issues = append(issues, anomalyIssue{"bells", fmt.Sprintf("%d of %d bells", o.BellCount, o.TotalBellCount)})
+		fixed.Optional = nil
+	}
+
+	return issues, fixed
+}
+
+// gekiLampFromJudgements lowers a lamp the judgements do not support
+func gekiLampFromJudgements(lamp BatchManualLampGeki, hit, miss int, bellsFull bool) BatchManualLampGeki {
+	switch {
+	case miss > 0 && gekiLampRanks[lamp] >= gekiLampRanks[FullComboGeki]:
+		if bellsFull {
+			return FullBell
+		}
+		return ClearGeki
+	case hit > 0 && lamp == AllBreak:
+		return FullComboGeki
+	default:
+		return lamp
+	}
+}
+
+// screenScore applies the anomaly policy to one play. It returns the play to
+// export, which is the fixed copy under the fix policy, and whether to export
+// it at all.
+func screenScore[T any](opts exportOptions, stats *exportStats, playlogID int64, identifier, difficulty string, issues []anomalyIssue, score, fixed T) (T, bool) {
+	if len(issues) == 0 {
+		return score, true
+	}
+	stats.Anomalies++
+
+	policy, _ := parseAnomalyPolicy(string(opts.AnomalyPolicy))
+	action := map[anomalyPolicy]string{anomalyExclude: "excluded", anomalyFix: "fixed", anomalyKeep: "kept"}[policy]
+	if opts.anomalies != nil {
+		opts.anomalies.Anomalies = append(opts.anomalies.Anomalies, anomaly{
+			PlaylogID:  playlogID,
+			Identifier: identifier,
+			Difficulty: difficulty,
+			Issues:     issues,
+			Action:     action,
+		})
+	}
+
+	switch policy {
+	case anomalyKeep:
+		return score, true
+	case anomalyFix:
+		return fixed, true
+	default:
+		return score, false
+	}
+}
+
+// summary counts the flagged plays per action and rule, e.g.
+// "3 anomalous plays: 2 excluded, 1 annotated (date-range 1, note-count 2)"
+func (r *anomalyReport) summary() string {
+	rules := make(map[string]int)
+	actions := make(map[string]int)
+	var actionOrder []string
+	for _, a := range r.Anomalies {
+		if actions[a.Action] == 0 {
+			actionOrder = append(actionOrder, a.Action)
+		}
+		actions[a.Action]++
+		for _, issue := range a.Issues {
+			rules[issue.Rule]++
+		}
+	}
+	var names []string
+	for rule := range rules {
+		names = append(names, rule)
+	}
+	sort.Strings(names)
+
+	var actionCounts, ruleCounts []string
+	for _, action := range actionOrder {
+		actionCounts = append(actionCounts, fmt.Sprintf("%d %s", actions[action], action))
+	}
+	for _, rule := range names {
+		ruleCounts = append(ruleCounts, fmt.Sprintf("%s %d", rule, rules[rule]))
+	}
+	return fmt.Sprintf("%d anomalous plays: %s (%s)", len(r.Anomalies), strings.Join(actionCounts, ", "), strings.Join(ruleCounts, ", "))
+}
+
+// writeAnomalyReport logs every flagged play and saves the list next to the
+// export, returning the path
+func writeAnomalyReport(report *anomalyReport) (string, error) {
+	for _, a := range report.Anomalies {
+		var details []string
+		for _, issue := range a.Issues {
+			details = append(details, issue.Detail)
+		}
+		log.Printf("Playlog %d (%s %s) %s: %s", a.PlaylogID, a.Identifier, a.Difficulty, a.Action, strings.Join(details, "; "))
+	}
+
+	if err := os.MkdirAll("exports", 0755); err != nil {
+		return "", fmt.Errorf("failed to create exports directory: %w", err)
+	}
+	data, err := json.MarshalIndent(report, "", " ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal anomaly report: %w", err)
+	}
+	path := fmt.Sprintf("exports/%s_anomalies.json", report.Game)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return "", fmt.Errorf("failed to write anomaly report: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// segatoolsDefaultAimePath is used when segatools.ini has no aimePath
+const segatoolsDefaultAimePath = `DEVICE\aime.txt`
+
+// readSegatoolsAccessCode reads the card number segatools emulates, either
+// from an aime.txt directly or from the aimePath of a segatools.ini
+func readSegatoolsAccessCode(path string) (string, error) {
+	path = strings.Trim(strings.TrimSpace(path), `"`)
+	if path == "" {
+		return "", fmt.Errorf("no aime.txt or segatools.ini path given")
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".ini") {
+		aimePath, err := segatoolsAimePath(path)
+		if err != nil {
+			return "", err
+		}
+		path = aimePath
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	// aime.txt holds the access code on its first line
+	code, _, _ := strings.Cut(string(data), "\n")
+	code = strings.TrimPrefix(strings.TrimSpace(code), "\ufeff")
+	if code == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return code, nil
+}
+
+// segatoolsAimePath finds the aimePath in the [aime] section of a
+// segatools.ini. Relative paths are resolved from the ini's directory, which
+// is where the game is started from.
+func segatoolsAimePath(iniPath string) (string, error) {
+	file, err := os.Open(iniPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", iniPath, err)
+	}
+	defer file.Close()
+
+	aimePath := segatoolsDefaultAimePath
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || section != "aime" || !strings.EqualFold(strings.TrimSpace(key), "aimePath") {
+			continue
+		}
+		if value = strings.Trim(strings.TrimSpace(value), `"`); value != "" {
+			aimePath = value
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", iniPath, err)
+	}
+
+	// segatools runs on Windows, so the path uses backslashes
+	aimePath = filepath.FromSlash(strings.ReplaceAll(aimePath, `\`, "/"))
+	if !filepath.IsAbs(aimePath) && !isWindowsDrivePath(aimePath) {
+		aimePath = filepath.Join(filepath.Dir(iniPath), aimePath)
+	}
+	return aimePath, nil
+}
+
+func isWindowsDrivePath(path string) bool {
+	return len(path) >= 2 && path[1] == ':'
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+const (
+	statsDays        = 30
+	statsChartHeight = 6
+)
+
+var sparkBlocks = []rune("▁▂▃▄▅▆▇█")
+
+// userStats holds the playlog rows of the selected user for the stats view
+type userStats struct {
+	records []scoreRecord
+	// charts are the played charts, most played first
+	charts []chartKey
+}
+
+type statsMsg struct {
+	stats *userStats
+	err   error
+}
+
+func fetchUserStats(db *sql.DB, game string, userID string) tea.Cmd {
+	return func() tea.Msg {
+		records, err := fetchScoreRecords(db, game, userID)
+		if err != nil {
+			return statsMsg{err: err}
+		}
+
+		return statsMsg{stats: &userStats{records: records, charts: chartsByPlayCount(records)}}
+	}
+}
+
+// chartsByPlayCount lists the played charts, most played first
+func chartsByPlayCount(records []scoreRecord) []chartKey {
+	playCounts := make(map[chartKey]int)
+	var charts []chartKey
+	for _, record := range records {
+		if playCounts[record.chart()] == 0 {
+			charts = append(charts, record.chart())
+		}
+		playCounts[record.chart()]++
+	}
+	sort.SliceStable(charts, func(i, j int) bool { return playCounts[charts[i]] > playCounts[charts[j]] })
+	return charts
+}
+
+// updateStats handles key presses while the stats view is shown
+func (m model) updateStats(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "ctrl+c", "q":
+		return m, tea.Quit
+	case "esc":
+		m.view = "userDisplay"
+		return m, nil
+	case "right", "l":
+		if m.stats != nil && len(m.stats.charts) > 0 {
+			m.statsChart = (m.statsChart + 1) % len(m.stats.charts)
+		}
+	case "left", "h":
+		if m.stats != nil && len(m.stats.charts) > 0 {
+			m.statsChart = (m.statsChart + len(m.stats.charts) - 1) % len(m.stats.charts)
+		}
+	}
+	return m, nil
+}
+
+func (m model) statsView() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Stats for %s (%s)\n\n", m.userName, m.selectedGame)
+
+	switch {
+	case m.statsErr != "":
+		fmt.Fprintf(&b, "Error: %s\n\n", m.statsErr)
+	case m.stats == nil:
+		b.WriteString("Loading...\n\n")
+	case len(m.stats.records) == 0:
+		b.WriteString("No plays found.\n\n")
+	default:
+		b.WriteString(renderScoreProgression(m.stats, m.statsChart))
+		b.WriteString("\n")
+		b.WriteString(renderPlaysPerDay(m.stats.records))
+		b.WriteString("\n")
+		b.WriteString(renderLampsPerDifficulty(m.stats.records))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("Left/Right to change chart, Esc to go back.")
+	return b.String()
+}
+
+// renderScoreProgression draws a sparkline of every score on one chart
+func renderScoreProgression(stats *userStats, index int) string {
+	chart := stats.charts[index]
+
+	var scores []int
+	var first, last *time.Time
+	for _, record := range stats.records {
+		if record.chart() != chart {
+			continue
+		}
+		scores = append(scores, record.Score)
+		if record.Time != nil {
+			if first == nil {
+				first = record.Time
+			}
+			last = record.Time
+		}
+	}
+
+	lowest, highest := scores[0], scores[0]
+	for _, score := range scores {
+		lowest = min(lowest, score)
+		highest = max(highest, score)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Score over time: %s %s (%d/%d, %d plays)\n", chart.Identifier, chart.Difficulty, index+1, len(stats.charts), len(scores))
+	b.WriteString("  ")
+	for _, score := range scores {
+		level := 0
+		if highest > lowest {
+			level = (score - lowest) * (len(sparkBlocks) - 1) / (highest - lowest)
+		}
+		b.WriteRune(sparkBlocks[level])
+	}
+	fmt.Fprintf(&b, "\n  min %d  max %d  latest %d", lowest, highest, scores[len(scores)-1])
+	if first != nil {
+		fmt.Fprintf(&b, "  (%s to %s)", first.Format("2006-01-02"), last.Format("2006-01-02"))
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// renderPlaysPerDay draws a column chart of the last 30 days with plays
+func renderPlaysPerDay(records []scoreRecord) string {
+	var lastPlay *time.Time
+	counts := make(map[string]int)
+	for _, record := range records {
+		if record.Time == nil {
+			continue
+		}
+		counts[record.Time.Format("2006-01-02")]++
+		if lastPlay == nil || record.Time.After(*lastPlay) {
+			lastPlay = record.Time
+		}
+	}
+	if lastPlay == nil {
+		return "Plays per day: no dated plays\n"
+	}
+
+	days := make([]int, statsDays)
+	peak := 0
+	start := lastPlay.AddDate(0, 0, -(statsDays - 1))
+	for i := range days {
+		days[i] = counts[start.AddDate(0, 0, i).Format("2006-01-02")]
+		peak = max(peak, days[i])
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Plays per day (%s to %s, peak %d)\n", start.Format("2006-01-02"), lastPlay.Format("2006-01-02"), peak)
+
+	// Each row covers 1/statsChartHeight of the peak, using partial blocks for the top
+	for row := statsChartHeight; row >= 1; row-- {
+		b.WriteString("  ")
+		for _, count := range days {
+			eighths := count * statsChartHeight * 8 / peak
+			filled := eighths - (row-1)*8
+			switch {
+			case filled >= 8:
+				b.WriteRune('█')
+			case filled > 0:
+				b.WriteRune(sparkBlocks[filled-1])
+			default:
+				b.WriteRune(' ')
+			}
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("  " + strings.Repeat("‾", statsDays) + "\n")
+	return b.String()
+}
+
+// renderLampsPerDifficulty counts the best lamp of each chart per difficulty
+func renderLampsPerDifficulty(records []scoreRecord) string {
+	bests := personalBests(records)
+
+	var difficulties []string
+	var lamps []string
+	lampRanks := make(map[string]int)
+	counts := make(map[string]map[string]int)
+	for _, best := range bests {
+		if counts[best.Chart.Difficulty] == nil {
+			counts[best.Chart.Difficulty] = make(map[string]int)
+			difficulties = append(difficulties, best.Chart.Difficulty)
+		}
+		if _, ok := lampRanks[best.Lamp]; !ok {
+			lamps = append(lamps, best.Lamp)
+			lampRanks[best.Lamp] = best.LampRank
+		}
+		counts[best.Chart.Difficulty][best.Lamp]++
+	}
+	sort.Strings(difficulties)
+	sort.Slice(lamps, func(i, j int) bool { return lampRanks[lamps[i]] > lampRanks[lamps[j]] })
+
+	var b strings.Builder
+	b.WriteString("Lamps per difficulty\n")
+	fmt.Fprintf(&b, "  %-10s", "")
+	for _, lamp := range lamps {
+		fmt.Fprintf(&b, " %*s", max(len(lamp), 4), lamp)
+	}
+	b.WriteString("\n")
+	for _, difficulty := range difficulties {
+		fmt.Fprintf(&b, "  %-10s", difficulty)
+		for _, lamp := range lamps {
+			fmt.Fprintf(&b, " %*d", max(len(lamp), 4), counts[difficulty][lamp])
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// importResult counts what an import did (or would do on a dry run)
+type importResult struct {
+	Charts    int
+	Inserted  int
+	Updated   int
+	Unchanged int
+	Skipped   int
+}
+
+func (r importResult) String() string {
+	return fmt.Sprintf("%d charts: %d inserted, %d raised, %d unchanged, %d skipped", r.Charts, r.Inserted, r.Updated, r.Unchanged, r.Skipped)
+}
+
+// count records the rows affected by an INSERT ... ON DUPLICATE KEY UPDATE,
+// which MySQL reports as 1 for an insert, 2 for an update and 0 for no change
+func (r *importResult) count(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	switch affected {
+	case 0:
+		r.Unchanged++
+	case 1:
+		r.Inserted++
+	default:
+		r.Updated++
+	}
+	return nil
+}
+
+// importedChart is the best of every score for one chart in the import file
+type importedChart struct {
+	MusicID  int64
+	Level    int
+	Plays    int
+	Score    int
+	LampRank int
+	MaxCombo int
+	// Miss is nil until a score with judgements was seen
+	Miss       *int
+	Difficulty string
+}
+
+// Ranks follow the in-game score rank thresholds, lowest first
+var chuniScoreRanks = []int{0, 500000, 600000, 700000, 800000, 900000, 925000, 950000, 975000, 990000, 1000000, 1005000, 1007500, 1009000}
+var gekiScoreRanks = []int{0, 500000, 700000, 750000, 800000, 850000, 900000, 940000, 970000, 990000, 1000000, 1007500}
+
+func scoreRank(ranks []int, score int) int {
+	rank := 0
+	for i, threshold := range ranks {
+		if score >= threshold {
+			rank = i
+		}
+	}
+	return rank
+}
+
+// mergeImportedScore folds a score into the per-chart bests. miss is nil for
+// scores without judgements, which say nothing about the fewest misses.
+func mergeImportedScore(charts map[[2]int64]*importedChart, musicID int64, level int, difficulty string, score, lampRank, maxCombo int, miss *int) {
+	key := [2]int64{musicID, int64(level)}
+	chart, ok := charts[key]
+	if !ok {
+		chart = &importedChart{MusicID: musicID, Level: level, Difficulty: difficulty}
+		charts[key] = chart
+	}
+	chart.Plays++
+	chart.Score = max(chart.Score, score)
+	chart.LampRank = max(chart.LampRank, lampRank)
+	chart.MaxCombo = max(chart.MaxCombo, maxCombo)
+	if miss != nil && (chart.Miss == nil || *miss < *chart.Miss) {
+		chart.Miss = miss
+	}
+}
+
+// importedScore is one score of an import file, whichever format it came in
+type importedScore struct {
+	MusicID    int64
+	Difficulty string
+	Score      int
+	Lamp       string
+	MaxCombo   int
+	Miss       *int
+}
+
+// tachiScoreDocument is a score as Tachi stores and exports it. It only names
+// its chart by Tachi's chartID, the in-game ID comes from the chart documents.
+type tachiScoreDocument struct {
+	Game      string `json:"game"`
+	ChartID   string `json:"chartID"`
+	SongID    int64  `json:"songID"`
+	ScoreData struct {
+		Score      int            `json:"score"`
+		Lamp       string         `json:"lamp"`
+		Judgements map[string]int `json:"judgements"`
+		Optional   struct {
+			MaxCombo int `json:"maxCombo"`
+		} `json:"optional"`
+	} `json:"scoreData"`
+}
+
+type tachiChartDocument struct {
+	ChartID    string `json:"chartID"`
+	SongID     int64  `json:"songID"`
+	Difficulty string `json:"difficulty"`
+	Data       struct {
+		InGameID *int64 `json:"inGameID"`
+	} `json:"data"`
+}
+
+// parseBatchManualScores reads a batch-manual file, as written by the export
+func parseBatchManualScores(data []byte, game string) ([]importedScore, int, error) {
+	var tachiImport struct {
+		Meta struct {
+			Game string `json:"game"`
+		} `json:"meta"`
+		Scores []struct {
+			Identifier string `json:"identifier"`
+			MatchType  string `json:"matchType"`
+			Difficulty string `json:"difficulty"`
+			Score      int    `json:"score"`
+			Lamp       string `json:"lamp"`
+			Judgements *struct {
+				Miss *int `json:"miss"`
+			} `json:"judgements"`
+			Optional *struct {
+				MaxCombo int `json:"maxCombo"`
+			} `json:"optional"`
+		} `json:"scores"`
+	}
+	if err := json.Unmarshal(data, &tachiImport); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse import file: %w", err)
+	}
+	if tachiImport.Meta.Game != game {
+		return nil, 0, fmt.Errorf("import file is for %q, not %s", tachiImport.Meta.Game, game)
+	}
+
+	var scores []importedScore
+	skipped := 0
+	for _, score := range tachiImport.Scores {
+		musicID, err := strconv.ParseInt(score.Identifier, 10, 64)
+		if err != nil || score.MatchType != "inGameID" {
+			skipped++
+			continue
+		}
+		imported := importedScore{MusicID: musicID, Difficulty: score.Difficulty, Score: score.Score, Lamp: score.Lamp}
+		if score.Optional != nil {
+			imported.MaxCombo = score.Optional.MaxCombo
+		}
+		if score.Judgements != nil {
+			imported.Miss = score.Judgements.Miss
+		}
+		scores = append(scores, imported)
+	}
+	return scores, skipped, nil
+}
+
+// parseTachiScoreDocuments reads scores exported from Tachi. The file is
+// either an object holding "scores" (or "pbs") along with their "charts", as
+// Tachi's API returns them, or a bare list of scores whose charts come from
+// chartsPath. Scores of charts without an in-game ID are skipped.
+func parseTachiScoreDocuments(data []byte, game string, chartsPath string) ([]importedScore, int, error) {
+	var documents struct {
+		Scores []tachiScoreDocument `json:"scores"`
+		PBs    []tachiScoreDocument `json:"pbs"`
+		Charts []tachiChartDocument `json:"charts"`
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		if err := json.Unmarshal(data, &documents.Scores); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse import file: %w", err)
+		}
+	} else if err := json.Unmarshal(data, &documents); err != nil {
+		return nil, 0, fmt.Errorf("failed to parse import file: %w", err)
+	}
+	documents.Scores = append(documents.Scores, documents.PBs...)
+
+	if chartsPath != "" {
+		chartData, err := os.ReadFile(chartsPath)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read chart file: %w", err)
+		}
+		var charts []tachiChartDocument
+		if err := json.Unmarshal(chartData, &charts); err != nil {
+			return nil, 0, fmt.Errorf("failed to parse chart file: %w", err)
+		}
+		documents.Charts = append(documents.Charts, charts...)
+	}
+	if len(documents.Scores) > 0 && len(documents.Charts) == 0 {
+		return nil, 0, fmt.Errorf("scores from Tachi only name their chart by chartID, pass the chart documents with -charts")
+	}
+
+	charts := make(map[string]tachiChartDocument)
+	for _, chart := range documents.Charts {
+		charts[chart.ChartID] = chart
+	}
+
+	var scores []importedScore
+	skipped := 0
+	for _, score := range documents.Scores {
+		if score.Game != "" && score.Game != game {
+			return nil, 0, fmt.Errorf("import file has scores for %q, not %s", score.Game, game)
+		}
+		chart, ok := charts[score.ChartID]
+		if !ok || chart.SongID != score.SongID || chart.Data.InGameID == nil {
+			skipped++
+			continue
+		}
+		scores = append(scores, importedScore{
+			MusicID:    *chart.Data.InGameID,
+			Difficulty: chart.Difficulty,
+			Score:      score.ScoreData.Score,
+			Lamp:       score.ScoreData.Lamp,
+			MaxCombo:   score.ScoreData.Optional.MaxCombo,
+		})
+		if miss, ok := score.ScoreData.Judgements["miss"]; ok {
+			scores[len(scores)-1].Miss = &miss
+		}
+	}
+	return scores, skipped, nil
+}
+
+// importTachiScores writes the scores of a batch-manual file or of Tachi's
+// own score export into the user's best score table. Existing bests are never
+// lowered, everything runs in one transaction and a dry run rolls it back
+// after counting the changes.
+func importTachiScores(db *sql.DB, game string, userID string, path string, chartsPath string, dryRun bool) (importResult, error) {
+	var result importResult
+
+	if err := userExists(db, userID); err != nil {
+		return result, err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return result, fmt.Errorf("failed to read import file: %w", err)
+	}
+
+	// Batch-manual files carry a meta block, Tachi's score documents do not
+	var header struct {
+		Meta *json.RawMessage `json:"meta"`
+	}
+	json.Unmarshal(data, &header)
+
+	var scores []importedScore
+	if header.Meta != nil {
+		scores, result.Skipped, err = parseBatchManualScores(data, strings.ToLower(game))
+	} else {
+		scores, result.Skipped, err = parseTachiScoreDocuments(data, strings.ToLower(game), chartsPath)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	charts := make(map[[2]int64]*importedChart)
+	var upsert string
+	var ranks []int
+
+	switch game {
+	case "Chunithm":
+		levels := map[string]int{"BASIC": 0, "ADVANCED": 1, "EXPERT": 2, "MASTER": 3, "ULTIMA": 4}
+		for _, score := range scores {
+			level, ok := levels[score.Difficulty]
+			if !ok {
+				result.Skipped++
+				continue
+			}
+			mergeImportedScore(charts, score.MusicID, level, score.Difficulty, score.Score, chuniLampRanks[BatchManualLampChuni(score.Lamp)], score.MaxCombo, score.Miss)
+		}
+
+		ranks = chuniScoreRanks
+		upsert = `
+			INSERT INTO chuni_score_best (
+				user, musicId, level, playCount, scoreMax, resRequestCount, resAcceptCount,
+				resSuccessCount, missCount, maxComboCount, isFullCombo, isAllJustice,
+				isSuccess, fullChain, maxChain, scoreRank, isLock, theoryCount
+			) VALUES (?, ?, ?, ?, ?, 0, 0, 0, COALESCE(?, DEFAULT(missCount)), ?, ?, ?, ?, 0, 0, ?, 0, 0)
+			ON DUPLICATE KEY UPDATE
+				scoreMax = GREATEST(scoreMax, VALUES(scoreMax)),
+				scoreRank = GREATEST(scoreRank, VALUES(scoreRank)),
+				maxComboCount = GREATEST(maxComboCount, VALUES(maxComboCount)),
+				isFullCombo = GREATEST(isFullCombo, VALUES(isFullCombo)),
+				isAllJustice = GREATEST(isAllJustice, VALUES(isAllJustice)),
+				isSuccess = GREATEST(isSuccess, VALUES(isSuccess))`
+
+	case "Ongeki":
+		levels := make(map[string]int)
+		for level, difficulty := range DIFFICULTY_MAP {
+			levels[difficulty] = level
+		}
+		for _, score := range scores {
+			level, ok := levels[score.Difficulty]
+			if !ok {
+				result.Skipped++
+				continue
+			}
+			mergeImportedScore(charts, score.MusicID, level, score.Difficulty, score.Score, gekiLampRanks[BatchManualLampGeki(score.Lamp)], score.MaxCombo, score.Miss)
+		}
+
+		ranks = gekiScoreRanks
+		upsert = `
+			INSERT INTO ongeki_score_best (
+				user, musicId, level, playCount, techScoreMax, techScoreRank, battleScoreMax,
+				battleScoreRank, maxComboCount, maxOverKill, maxTeamOverKill, isFullBell,
+				isFullCombo, isAllBreake, isLock, clearStatus, isStoryWatched
+			) VALUES (?, ?, ?, ?, ?, ?, 0, 0, ?, 0, 0, ?, ?, ?, 0, ?, 0)
+			ON DUPLICATE KEY UPDATE
+				techScoreMax = GREATEST(techScoreMax, VALUES(techScoreMax)),
+				techScoreRank = GREATEST(techScoreRank, VALUES(techScoreRank)),
+				maxComboCount = GREATEST(maxComboCount, VALUES(maxComboCount)),
+				isFullBell = GREATEST(isFullBell, VALUES(isFullBell)),
+				isFullCombo = GREATEST(isFullCombo, VALUES(isFullCombo)),
+				isAllBreake = GREATEST(isAllBreake, VALUES(isAllBreake)),
+				clearStatus = GREATEST(clearStatus, VALUES(clearStatus))`
+
+	default:
+		return result, fmt.Errorf("import is not supported for %s", game)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(upsert)
+	if err != nil {
+		return result, fmt.Errorf("failed to prepare import: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, chart := range charts {
+		result.Charts++
+		rank := scoreRank(ranks, chart.Score)
+
+		var res sql.Result
+		if game == "Chunithm" {
+			isFullCombo := boolToInt(chart.LampRank >= chuniLampRanks[FullCombo])
+			isAllJustice := boolToInt(chart.LampRank >= chuniLampRanks[AllJustice])
+			isSuccess := boolToInt(chart.LampRank >= chuniLampRanks[Clear])
+			res, err = stmt.Exec(userID, chart.MusicID, chart.Level, chart.Plays, chart.Score, chart.Miss, chart.MaxCombo, isFullCombo, isAllJustice, isSuccess, rank)
+		} else {
+			// FULL BELL ranks below FULL COMBO in the lamp order but is an
+			// independent flag in the table, so only a FULL BELL lamp sets it
+			isFullBell := boolToInt(chart.LampRank == gekiLampRanks[FullBell])
+			isFullCombo := boolToInt(chart.LampRank >= gekiLampRanks[FullComboGeki])
+			isAllBreak := boolToInt(chart.LampRank >= gekiLampRanks[AllBreak])
+			clearStatus := boolToInt(chart.LampRank >= gekiLampRanks[ClearGeki])
+			res, err = stmt.Exec(userID, chart.MusicID, chart.Level, chart.Plays, chart.Score, rank, chart.MaxCombo, isFullBell, isFullCombo, isAllBreak, clearStatus)
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to import %d %s: %w", chart.MusicID, chart.Difficulty, err)
+		}
+		if err := result.count(res); err != nil {
+			return result, err
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit import: %w", err)
+	}
+	return result, nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
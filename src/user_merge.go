@@ -0,0 +1,196 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// mergeBestTable describes how two best score rows of the same chart combine
+type mergeBestTable struct {
+	Name string
+	Key  []string
+	// Higher keeps the larger value, Sum adds both and Lower keeps the smaller
+	Higher []string
+	Sum    []string
+	Lower  []string
+}
+
+var mergeBestTables = []mergeBestTable{
+	{
+		Name:   "chuni_score_best",
+		Key:    []string{"musicId", "level"},
+		Higher: []string{"scoreMax", "scoreRank", "maxComboCount", "isFullCombo", "isAllJustice", "isSuccess", "fullChain", "maxChain", "theoryCount"},
+		Sum:    []string{"playCount"},
+		Lower:  []string{"missCount"},
+	},
+	{
+		Name:   "ongeki_score_best",
+		Key:    []string{"musicId", "level"},
+		Higher: []string{"techScoreMax", "techScoreRank", "battleScoreMax", "battleScoreRank", "maxComboCount", "maxOverKill", "maxTeamOverKill", "isFullBell", "isFullCombo", "isAllBreake", "clearStatus"},
+		Sum:    []string{"playCount"},
+	},
+	{
+		Name:   "mai2_score_best",
+		Key:    []string{"musicId", "level"},
+		Higher: []string{"achievement", "comboStatus", "syncStatus", "deluxscoreMax", "scoreRank"},
+		Sum:    []string{"playCount"},
+	},
+	{
+		Name:   "wacca_score_best",
+		Key:    []string{"song_id", "chart_id"},
+		Higher: []string{"score", "best_combo", "rating"},
+		Sum:    []string{"play_ct", "clear_ct", "missless_ct", "fullcombo_ct", "allmarv_ct"},
+		Lower:  []string{"lowest_miss_ct"},
+	},
+}
+
+// Playlogs never conflict, every row simply changes owner
+var mergePlaylogTables = []string{"chuni_score_playlog", "ongeki_score_playlog", "mai2_playlog", "wacca_score_playlog"}
+
+type mergeTableResult struct {
+	Table string
+	// Moved rows changed owner, Merged rows were folded into the target's
+	// best of the same chart
+	Moved  int64
+	Merged int64
+}
+
+// mergeResult is what a merge did, or would do when it was only previewed
+type mergeResult struct {
+	Source string
+	Target string
+	Cards  int64
+	Tables []mergeTableResult
+}
+
+func (r mergeResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Merging user %s into user %s:\n", r.Source, r.Target)
+	fmt.Fprintf(&b, "  aime_card: %d cards relinked\n", r.Cards)
+	for _, table := range r.Tables {
+		if table.Merged > 0 {
+			fmt.Fprintf(&b, "  %s: %d rows moved, %d charts merged keeping the higher values\n", table.Table, table.Moved, table.Merged)
+		} else {
+			fmt.Fprintf(&b, "  %s: %d rows moved\n", table.Table, table.Moved)
+		}
+	}
+	b.WriteString("Profiles, items and settings of the source user are left in place.")
+	return b.String()
+}
+
+// mergeUsers moves the playlogs, best scores and cards of source to target in
+// one transaction. Unless commit is set the transaction is rolled back, which
+// makes the result an exact preview.
+func mergeUsers(db *sql.DB, source string, target string, commit bool) (mergeResult, error) {
+	result := mergeResult{Source: source, Target: target}
+	if source == target {
+		return result, fmt.Errorf("cannot merge user %s into itself", source)
+	}
+	for _, user := range []string{source, target} {
+		if err := userExists(db, user); err != nil {
+			return result, err
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, table := range mergeBestTables {
+		columns, err := targetColumns(tx, table.Name)
+		if err != nil {
+			return result, err
+		}
+		// Tables of games the server does not run are skipped
+		if columns == nil {
+			continue
+		}
+		merged, err := mergeBestScores(tx, table, columns, source, target)
+		if err != nil {
+			return result, err
+		}
+		moved, err := moveUserRows(tx, table.Name, source, target)
+		if err != nil {
+			return result, err
+		}
+		result.Tables = append(result.Tables, mergeTableResult{Table: table.Name, Moved: moved, Merged: merged})
+	}
+
+	for _, table := range mergePlaylogTables {
+		columns, err := targetColumns(tx, table)
+		if err != nil {
+			return result, err
+		}
+		if columns == nil {
+			continue
+		}
+		moved, err := moveUserRows(tx, table, source, target)
+		if err != nil {
+			return result, err
+		}
+		result.Tables = append(result.Tables, mergeTableResult{Table: table, Moved: moved})
+	}
+
+	if result.Cards, err = moveUserRows(tx, "aime_card", source, target); err != nil {
+		return result, err
+	}
+
+	if !commit {
+		return result, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("failed to commit merge: %w", err)
+	}
+	return result, nil
+}
+
+// mergeBestScores folds the source's bests into the target's rows of the same
+// chart and deletes them, returning how many charts both users had played
+func mergeBestScores(tx *sql.Tx, table mergeBestTable, columns map[string]bool, source string, target string) (int64, error) {
+	var join []string
+	for _, key := range table.Key {
+		join = append(join, fmt.Sprintf("t.`%s` = s.`%s`", key, key))
+	}
+	on := strings.Join(join, " AND ")
+
+	var set []string
+	for _, column := range table.Higher {
+		if columns[column] {
+			set = append(set, fmt.Sprintf("t.`%s` = GREATEST(t.`%s`, s.`%s`)", column, column, column))
+		}
+	}
+	for _, column := range table.Sum {
+		if columns[column] {
+			set = append(set, fmt.Sprintf("t.`%s` = t.`%s` + s.`%s`", column, column, column))
+		}
+	}
+	for _, column := range table.Lower {
+		if columns[column] {
+			set = append(set, fmt.Sprintf("t.`%s` = LEAST(t.`%s`, s.`%s`)", column, column, column))
+		}
+	}
+
+	if len(set) > 0 {
+		_, err := tx.Exec(fmt.Sprintf("UPDATE `%s` t JOIN `%s` s ON %s SET %s WHERE t.user = ? AND s.user = ?", table.Name, table.Name, on, strings.Join(set, ", ")), target, source)
+		if err != nil {
+			return 0, fmt.Errorf("failed to merge %s: %w", table.Name, err)
+		}
+	}
+
+	res, err := tx.Exec(fmt.Sprintf("DELETE s FROM `%s` s JOIN `%s` t ON %s WHERE t.user = ? AND s.user = ?", table.Name, table.Name, on), target, source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to merge %s: %w", table.Name, err)
+	}
+	return res.RowsAffected()
+}
+
+func moveUserRows(tx *sql.Tx, table string, source string, target string) (int64, error) {
+	res, err := tx.Exec(fmt.Sprintf("UPDATE `%s` SET user = ? WHERE user = ?", table), target, source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to move %s: %w", table, err)
+	}
+	return res.RowsAffected()
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+type BatchManualLampWacca string
+
+const (
+	AllMarvelous   BatchManualLampWacca = "ALL MARVELOUS"
+	FullComboWacca BatchManualLampWacca = "FULL COMBO"
+	Missless       BatchManualLampWacca = "MISSLESS"
+	ClearWacca     BatchManualLampWacca = "CLEAR"
+	FailedWacca    BatchManualLampWacca = "FAILED"
+)
+
+// WACCA chart_id values start at 1
+var WACCA_DIFFICULTY_MAP = map[int]string{
+	1: "NORMAL",
+	2: "HARD",
+	3: "EXPERT",
+	4: "INFERNO",
+}
+
+type BatchManualScoreWacca struct {
+	Identifier   string               `json:"identifier"`
+	MatchType    string               `json:"matchType"`
+	Score        int                  `json:"score"`
+	Lamp         BatchManualLampWacca `json:"lamp"`
+	Difficulty   string               `json:"difficulty"`
+	TimeAchieved *int64               `json:"timeAchieved,omitempty"`
+	Judgements   *struct {
+		Marvelous int `json:"marvelous"`
+		Great     int `json:"great"`
+		Good      int `json:"good"`
+		Miss      int `json:"miss"`
+	} `json:"judgements,omitempty"`
+	Optional *struct {
+		Fast     int `json:"fast"`
+		Slow     int `json:"slow"`
+		MaxCombo int `json:"maxCombo"`
+	} `json:"optional,omitempty"`
+
+	// PlaylogID is only written to the CSV and NDJSON exports
+	PlaylogID int64 `json:"-"`
+}
+
+type BatchManualImportWacca struct {
+	Meta struct {
+		Game     string `json:"game"`
+		Playtype string `json:"playtype"`
+		Service  string `json:"service"`
+	} `json:"meta"`
+	Scores []BatchManualScoreWacca `json:"scores"`
+}
+
+// waccaLamps is ordered from worst to best, matching the clear status the
+// game stores on each playlog
+var waccaLamps = []BatchManualLampWacca{FailedWacca, ClearWacca, Missless, FullComboWacca, AllMarvelous}
+
+func fetchWaccaExport(db *sql.DB, userID string, opts exportOptions) (*BatchManualImportWacca, exportStats, error) {
+	var tachiExport BatchManualImportWacca
+	var stats exportStats
+	tachiExport.Meta.Game = "wacca"
+	tachiExport.Meta.Playtype = "Single"
+	tachiExport.Meta.Service = "batch-artemis-export"
+	tachiExport.Scores = []BatchManualScoreWacca{} // Initialize slice to avoid `null` in JSON
+
+	rows, err := db.Query(`
+		SELECT
+			id, date, song_id, chart_id, score, clear, max_combo,
+			marv_ct, great_ct, good_ct, miss_ct, fast_ct, late_ct
+		FROM wacca_score_playlog
+		WHERE user = ?
+		ORDER BY date
+	`, userID)
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to fetch playlog: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var playlog struct {
+			ID       int64
+			Date     sql.NullString
+			SongID   sql.NullInt64
+			ChartID  sql.NullInt64
+			Score    sql.NullInt64
+			Clear    sql.NullInt64
+			MaxCombo sql.NullInt64
+			Marv     sql.NullInt64
+			Great    sql.NullInt64
+			Good     sql.NullInt64
+			Miss     sql.NullInt64
+			Fast     sql.NullInt64
+			Late     sql.NullInt64
+		}
+
+		err := rows.Scan(
+			&playlog.ID, &playlog.Date, &playlog.SongID, &playlog.ChartID, &playlog.Score, &playlog.Clear,
+			&playlog.MaxCombo, &playlog.Marv, &playlog.Great, &playlog.Good, &playlog.Miss,
+			&playlog.Fast, &playlog.Late,
+		)
+		if err != nil {
+			return nil, stats, fmt.Errorf("failed to scan playlog row: %v", err)
+		}
+
+		if !playlog.SongID.Valid || !playlog.ChartID.Valid || !playlog.Score.Valid {
+			continue
+		}
+
+		difficulty, ok := WACCA_DIFFICULTY_MAP[int(playlog.ChartID.Int64)]
+		if !ok {
+			continue
+		}
+
+		// Some servers only store a cleared flag, so also derive the lamp from
+		// the judgements and keep whichever is better
+		status := 0
+		if playlog.Clear.Valid && playlog.Clear.Int64 > 0 {
+			status = int(playlog.Clear.Int64)
+			if status >= len(waccaLamps) {
+				status = len(waccaLamps) - 1
+			}
+			derived := 1
+			if playlog.Miss.Int64 == 0 {
+				derived = 2
+				if playlog.Good.Int64 == 0 {
+					derived = 3
+					if playlog.Great.Int64 == 0 {
+						derived = 4
+					}
+				}
+			}
+			if derived > status {
+				status = derived
+			}
+		}
+		lamp := waccaLamps[status]
+
+		var timeAchieved *int64
+		var playDate *time.Time
+		if playlog.Date.Valid {
+			parsedTime, err := time.Parse("2006-01-02 15:04:05", playlog.Date.String)
+			if err != nil {
+				return nil, stats, fmt.Errorf("failed to parse date: %v", err)
+			}
+			timestamp := parsedTime.UnixMilli()
+			timeAchieved = &timestamp
+			playDate = &parsedTime
+		}
+
+		// WACCA playlogs carry no rom version, so that part of the filter never applies
+		if !opts.Filter.allowsPlay(playDate, difficulty, playlog.SongID.Int64, "") {
+			stats.Filtered++
+			continue
+		}
+
+		// Omnimix and custom charts could be matched to a different song on Tachi
+		if opts.Rules.excludes("wacca", playlog.SongID.Int64) {
+			stats.CustomExcluded++
+			continue
+		}
+
+		score := BatchManualScoreWacca{
+			Identifier:   fmt.Sprintf("%d", playlog.SongID.Int64),
+			MatchType:    "inGameID",
+			Score:        int(playlog.Score.Int64),
+			Lamp:         lamp,
+			Difficulty:   difficulty,
+			TimeAchieved: timeAchieved,
+			PlaylogID:    playlog.ID,
+		}
+
+		score.Judgements = &struct {
+			Marvelous int `json:"marvelous"`
+			Great     int `json:"great"`
+			Good      int `json:"good"`
+			Miss      int `json:"miss"`
+		}{
+			Marvelous: int(playlog.Marv.Int64),
+			Great:     int(playlog.Great.Int64),
+			Good:      int(playlog.Good.Int64),
+			Miss:      int(playlog.Miss.Int64),
+		}
+
+		score.Optional = &struct {
+			Fast     int `json:"fast"`
+			Slow     int `json:"slow"`
+			MaxCombo int `json:"maxCombo"`
+		}{
+			Fast:     int(playlog.Fast.Int64),
+			Slow:     int(playlog.Late.Int64),
+			MaxCombo: int(playlog.MaxCombo.Int64),
+		}
+
+		tachiExport.Scores = append(tachiExport.Scores, score)
+		stats.Exported++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, stats, err
+	}
+
+	if opts.BestOnly {
+		tachiExport.Scores = collapseToBests(tachiExport.Scores)
+		stats.Collapsed = stats.Exported - len(tachiExport.Scores)
+		stats.Exported = len(tachiExport.Scores)
+	}
+	tachiExport.Scores = skipSentScores(opts.sent, tachiExport.Scores, &stats)
+
+	return &tachiExport, stats, nil
+}
+
+func exportWaccaToTachi(tachiExportWacca *BatchManualImportWacca) error {
+	if err := os.MkdirAll("exports", 0755); err != nil {
+		return fmt.Errorf("failed to create exports directory: %w", err)
+	}
+
+	file, err := json.MarshalIndent(tachiExportWacca, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal export data: %w", err)
+	}
+
+	if err := os.WriteFile("exports/wacca_tachi_export.json", file, 0644); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+
+	return nil
+}